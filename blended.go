@@ -0,0 +1,94 @@
+package main
+
+// blendedNoise is the game's BlendedNoise, used by minecraft:old_blended_noise.
+// Its three octave noises are legacy PerlinNoises with every amplitude 1, so
+// only the perlins are kept, in the order returned by getOctaveNoise, where
+// octave i samples at 1/2^i of the frequency of octave 0.
+type blendedNoise struct {
+	minLimit, maxLimit, main []perlin
+
+	xzScale, yScale, xzFactor, yFactor, smearScaleMultiplier float64
+
+	// multipliers from block coordinates to the input of octave 0
+	xzMultiplier, yMultiplier float64
+}
+
+const (
+	blendedLimitOctaves = 16 // octaves -15 to 0
+	blendedMainOctaves  = 8  // octaves -7 to 0
+)
+
+// newBlendedNoise constructs the noise from r the way the game does for its
+// world, where r is the random forked from the positional factory of the seed
+// for minecraft:terrain. Worlds using the legacy random source are not
+// supported.
+func newBlendedNoise(r xoroshiro, xzScale, yScale, xzFactor, yFactor, smearScaleMultiplier float64) *blendedNoise {
+	return &blendedNoise{
+		minLimit: newLegacyOctaves(r, blendedLimitOctaves),
+		maxLimit: newLegacyOctaves(r, blendedLimitOctaves),
+		main:     newLegacyOctaves(r, blendedMainOctaves),
+
+		xzScale:              xzScale,
+		yScale:               yScale,
+		xzFactor:             xzFactor,
+		yFactor:              yFactor,
+		smearScaleMultiplier: smearScaleMultiplier,
+
+		xzMultiplier: 684.412 * xzScale,
+		yMultiplier:  684.412 * yScale,
+	}
+}
+
+// seededBlendedNoise constructs the noise the game creates for a world with
+// the given seed.
+func seededBlendedNoise(dimSeed int64, xzScale, yScale, xzFactor, yFactor, smearScaleMultiplier float64) *blendedNoise {
+	r := newXoroshiro(upgradeSeedTo128Bit(dimSeed)).forkFixed().fromHash("minecraft:terrain")
+	return newBlendedNoise(r, xzScale, yScale, xzFactor, yFactor, smearScaleMultiplier)
+}
+
+// newLegacyOctaves matches the legacy construction of a PerlinNoise with n
+// octaves ending at 0, all of amplitude 1. The highest frequency octave is
+// drawn from r first, followed by each lower one.
+func newLegacyOctaves(r xoroshiro, n int) []perlin {
+	o := make([]perlin, n)
+	for i := range o {
+		o[i] = newPerlin(r)
+	}
+	return o
+}
+
+// compute matches BlendedNoise.compute at a block position.
+func (n *blendedNoise) compute(c intCoord) float64 {
+	x := float64(c.x) * n.xzMultiplier
+	y := float64(c.y) * n.yMultiplier
+	z := float64(c.z) * n.xzMultiplier
+	mx := x / n.xzFactor
+	my := y / n.yFactor
+	mz := z / n.xzFactor
+	smear := n.yMultiplier * n.smearScaleMultiplier
+	mainSmear := smear / n.yFactor
+
+	var lo, hi, main float64
+	o := 1.0
+	for i := range n.main {
+		main += n.main[i].noiseFull(wrap(mx*o), wrap(my*o), wrap(mz*o), mainSmear*o, my*o) / o
+		o /= 2
+	}
+
+	// the main noise selects between the limit noises, which are only
+	// computed when needed
+	t := (main/10 + 1) / 2
+	useLo, useHi := t < 1, t > 0
+	o = 1.0
+	for i := 0; i < blendedLimitOctaves; i++ {
+		wx, wy, wz := wrap(x*o), wrap(y*o), wrap(z*o)
+		if useLo {
+			lo += n.minLimit[i].noiseFull(wx, wy, wz, smear*o, y*o) / o
+		}
+		if useHi {
+			hi += n.maxLimit[i].noiseFull(wx, wy, wz, smear*o, y*o) / o
+		}
+		o /= 2
+	}
+	return clampedLerp(lo/512, hi/512, t) / 128
+}
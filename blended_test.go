@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// The overworld's parameters for old_blended_noise.
+func overworldBlendedNoise(dimSeed int64) *blendedNoise {
+	return seededBlendedNoise(dimSeed, 0.25, 0.125, 80, 160, 8)
+}
+
+func TestBlendedNoiseConstruction(t *testing.T) {
+	n := overworldBlendedNoise(1)
+	r := newXoroshiro(upgradeSeedTo128Bit(1)).forkFixed().fromHash("minecraft:terrain")
+	// the min and max limit noises are drawn before the main noise, each
+	// starting with its octave 0
+	for i, want := range [][]perlin{n.minLimit, n.maxLimit, n.main} {
+		for j := range want {
+			if p := newPerlin(r); p.o != want[j].o {
+				t.Fatalf("perlin %d of noise %d has offset %v, expected %v", j, i, want[j].o, p.o)
+			}
+		}
+	}
+	if len(n.minLimit) != 16 || len(n.maxLimit) != 16 || len(n.main) != 8 {
+		t.Errorf("octave counts are %d, %d, %d", len(n.minLimit), len(n.maxLimit), len(n.main))
+	}
+}
+
+func TestBlendedNoiseReference(t *testing.T) {
+	// Values of the overworld's old_blended_noise. They were computed with a
+	// line by line transcription of the game's BlendedNoise, PerlinNoise,
+	// ImprovedNoise and XoroshiroRandomSource written apart from this code,
+	// not by the game itself. sel is the selector of the limit noises, which
+	// only uses the min limit noise below 0 and the max limit noise above 1.
+	for _, c := range []struct {
+		seed int64
+		at   intCoord
+		sel  float64
+		want float64
+	}{
+		{1, intCoord{0, 0, 0}, -2.416, -0.004268867170109929},
+		{1, intCoord{123, 45, -678}, -1.575, -0.0199057702183396},
+		{1, intCoord{-3000, 200, -2917}, -0.032, -0.3065464115635742},
+		{1, intCoord{-3000, 277, -2751}, 0.012, 0.49524147222133774},
+		{1, intCoord{-3000, 178, -2917}, 0.475, -0.24823606574004486},
+		{1, intCoord{-3000, -53, -2170}, 0.976, -0.716171443855726},
+		{1, intCoord{-3000, 145, -2585}, 1.049, 0.2393285080132177},
+		{-1, intCoord{123, 45, -678}, -1.340, -0.2133578970445172},
+		{8675309, intCoord{123, 45, -678}, -0.181, 0.03825131252504823},
+	} {
+		if v := overworldBlendedNoise(c.seed).compute(c.at); !(math.Abs(v-c.want) <= 1e-12*math.Abs(c.want)) {
+			t.Errorf("seed %d at %d,%d,%d with selector %g: %v, expected %v", c.seed, c.at.x, c.at.y, c.at.z, c.sel, v, c.want)
+		}
+	}
+}
+
+func TestBlendedNoiseValues(t *testing.T) {
+	n := overworldBlendedNoise(1)
+	var lo, hi float64
+	for x := int64(-4096); x <= 4096; x += 97 {
+		for y := int64(-64); y <= 320; y += 13 {
+			v := n.compute(intCoord{x, y, x / 3})
+			// every octave is within about 1.04 and the octaves' weights sum to
+			// just below 2^16, so the result is within about 1.04 * 2^16/512/128
+			if !(math.Abs(v) <= 1.04) {
+				t.Fatalf("value at %d,%d is %v", x, y, v)
+			}
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+	}
+	if !(lo < -0.1 && hi > 0.1) {
+		t.Errorf("values only range from %v to %v", lo, hi)
+	}
+
+	// the noise is determined by the seed
+	c := intCoord{123, 45, -678}
+	if a, b := n.compute(c), overworldBlendedNoise(1).compute(c); a != b {
+		t.Errorf("noises of the same seed differ, %v and %v", a, b)
+	}
+	if a, b := n.compute(c), overworldBlendedNoise(2).compute(c); a == b {
+		t.Errorf("noises of different seeds are equal, %v", a)
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+)
+
+// The emitted function is amplifier * (b + m*noise(x*scale + shift))
+// and the noise is a polynomial on each lattice cell of its two perlins, so
+// enclosures of the deviation from the identity and of its derivative over a
+// box of positions can be computed with interval arithmetic. A branch and
+// bound over boxes then proves the largest error over the usable range.
+
+const (
+	// unitRoundoff bounds the relative error of a double precision operation
+	unitRoundoff = 0x1p-53
+
+	// perlinMax bounds the magnitude of a perlin, as the interpolation never
+	// leaves the range of the corner values, each the sum of at most two
+	// offsets within [-1, 1]. perlinSlope bounds its derivative along a
+	// coordinate: 1 from the gradients, plus the largest slope of smoothStep,
+	// 15/8, times the largest difference between corner values, 4.
+	perlinMax   = 2.0
+	perlinSlope = 1 + 15.0/8*4
+
+	// perlinRounding bounds the rounding error of evaluating a perlin at a
+	// given input: about 30 operations on values within [-4, 4], each
+	// rounding by at most 4 units of roundoff, with the weights of the
+	// interpolation never amplifying an error.
+	perlinRounding = 256 * unitRoundoff
+
+	// the search stops once the bound is within this fraction, or one block,
+	// of the largest error found
+	certifyTolerance = 0.01
+
+	// defaultCertifyBoxes limits the boxes evaluated, after which the bound
+	// reached so far is reported
+	defaultCertifyBoxes = 100000
+
+	// maxDualCells limits the lattice cells a perlin is evaluated in
+	// separately for a box. Larger boxes are enclosed with perlinMax and
+	// perlinSlope instead.
+	maxDualCells = 16
+
+	// wrapLimit is the magnitude of perlin inputs from which the game wraps
+	// them, which the enclosures do not model
+	wrapLimit = 0x1p24
+)
+
+// certifyRange is the distance from the origin, along both horizontal
+// coordinates, that the error is certified over: the usable range of p if
+// known, otherwise the world.
+func (p dfParams) certifyRange() float64 {
+	if p.reach > 0 && p.reach < worldBorder {
+		return p.reach
+	}
+	return worldBorder
+}
+
+// inputRange bounds the magnitude of the inputs of the noises of p, before
+// the second perlin scales them, within the certified range.
+func (p dfParams) inputRange() float64 {
+	var c float64
+	for _, t := range append([]dfTerm{p.term()}, p.extra...) {
+		c = math.Max(c, math.Max(p.certifyRange()*p.scale+math.Max(math.Abs(t.x), math.Abs(t.z)), math.Abs(t.y)))
+	}
+	return c
+}
+
+// roundingMargin bounds the difference between the exact function, which the
+// bound is proven for, and the game's double precision evaluation of it at
+// positions within the certified range.
+func (p dfParams) roundingMargin() float64 {
+	terms := append([]dfTerm{p.term()}, p.extra...)
+	var noiseErr, sum float64
+	for _, t := range terms {
+		// the noise input is rounded when scaling and shifting the position,
+		// when the second perlin scales it and when each perlin adds its
+		// offset, which stays below 256
+		c := math.Max(p.certifyRange()*p.scale+math.Max(math.Abs(t.x), math.Abs(t.z)), math.Abs(t.y))
+		input := 4 * unitRoundoff * (c*secondScale + 256)
+		// for both perlins, three coordinates and the evaluation, then the
+		// sum of the perlins and its scaling
+		perlin := 3*perlinSlope*input + perlinRounding
+		noiseErr += math.Abs(t.m) * (vf*2*perlin + 2*unitRoundoff*2*vf*perlinMax)
+		sum += math.Abs(t.m) * 2 * vf * perlinMax
+	}
+	// multiplying and adding the terms, adding b and applying the amplifier
+	output := float64(2*len(terms)+1) * unitRoundoff * (math.Abs(p.b) + sum)
+	return math.Abs(p.amplifier()) * (noiseErr + output)
+}
+
+// certifiedError is the largest error of a function over the world, known to
+// lie between lower, an error found at an actual position, and upper.
+type certifiedError struct {
+	lower, upper float64
+	boxes        int // number of boxes evaluated
+}
+
+// dual evaluates the perlin and its derivative over positions c, where each
+// coordinate is a dual of the position in the input of the perlin and its
+// derivative. The positions may span several cells, each of which is
+// evaluated separately, up to maxDualCells. The coordinates must be small
+// enough for the game to not wrap them.
+func (n *perlin) dual(c [3]dual) dual {
+	var oc [3]dual
+	var cells [3][2]int64
+	count := 1.0
+	for i, o := range [3]float64{n.o.x, n.o.y, n.o.z} {
+		oc[i] = c[i].add(constDual(o))
+		lo, hi := math.Floor(oc[i].v.lo), math.Floor(oc[i].v.hi)
+		cells[i] = [2]int64{int64(lo), int64(hi)}
+		count *= hi - lo + 1
+	}
+	if !(count <= maxDualCells) {
+		r := dual{v: interval{-perlinMax, perlinMax}}
+		for k := range r.d {
+			r.d[k] = point(0)
+			for i := range c {
+				r.d[k] = r.d[k].add(interval{-perlinSlope, perlinSlope}.mul(c[i].d[k]))
+			}
+		}
+		return r
+	}
+
+	var r dual
+	first := true
+	for x := cells[0][0]; x <= cells[0][1]; x++ {
+		for y := cells[1][0]; y <= cells[1][1]; y++ {
+			for z := cells[2][0]; z <= cells[2][1]; z++ {
+				l := [3]int64{x, y, z}
+				var f [3]dual
+				for i := range f {
+					// the part of the positions inside this cell
+					f[i] = oc[i].sub(constDual(float64(l[i])))
+					f[i].v = f[i].v.intersect(interval{0, 1})
+				}
+				v := n.cellDual(l, f)
+				if first {
+					r, first = v, false
+				} else {
+					r = r.hull(v)
+				}
+			}
+		}
+	}
+	return r
+}
+
+// cellDual evaluates the perlin in the cell at lattice position l, at the
+// fractional positions f within [0, 1].
+func (n *perlin) cellDual(l [3]int64, f [3]dual) dual {
+	r := func(i int) int {
+		return int(n.p[i&0xFF] & 0xFF)
+	}
+	xb, yb, zb := int(l[0]), int(l[1]), int(l[2])
+	rx, rx1 := r(xb), r(xb+1)
+	rxy, rx1y, rxy1, rx1y1 := r(rx+yb), r(rx1+yb), r(rx+yb+1), r(rx1+yb+1)
+
+	// corner order as in perlin.vectors
+	hashes := [8]int{
+		r(rxy + zb), r(rx1y + zb), r(rxy1 + zb), r(rx1y1 + zb),
+		r(rxy + zb + 1), r(rx1y + zb + 1), r(rxy1 + zb + 1), r(rx1y1 + zb + 1),
+	}
+	var d [8]dual
+	for c, h := range hashes {
+		g := gradients[h&0xF]
+		d[c] = constDual(0)
+		for i := range f {
+			// the offset to the corner, multiplied by a gradient of -1, 0 or 1
+			o := f[i].sub(constDual(float64(c >> i & 1)))
+			switch g[i] {
+			case 1:
+				d[c] = d[c].add(o)
+			case -1:
+				d[c] = d[c].sub(o)
+			}
+		}
+	}
+
+	sx, sy, sz := smoothStepDual(f[0]), smoothStepDual(f[1]), smoothStepDual(f[2])
+	lerp2 := func(a, b, c, d dual) dual {
+		return a.lerp(sx, b).lerp(sy, c.lerp(sx, d))
+	}
+	return lerp2(d[0], d[1], d[2], d[3]).lerp(sz, lerp2(d[4], d[5], d[6], d[7]))
+}
+
+// dual evaluates the noise and its derivative over positions c.
+func (nn *normalNoise) dual(c [3]dual) dual {
+	var c2 [3]dual
+	for i := range c {
+		c2[i] = c[i].scale(secondScale)
+	}
+	return nn.n1.dual(c).add(nn.n2.dual(c2)).scale(vf)
+}
+
+// deviationDual encloses the error of p, its value minus the coordinate, and
+// its derivatives along the axis and the other horizontal coordinate, where
+// the axis coordinate lies in t and the other in s, both in blocks.
+func (p dfParams) deviationDual(nn *normalNoise, t, s interval) dual {
+	td := variable(t, 0)
+	sd := variable(s, 1)
+	in := func(d dual, shift float64) dual {
+		return d.scale(p.scale).add(constDual(shift))
+	}
+	c := [3]dual{in(td, p.x), constDual(p.y), in(sd, p.z)}
+	if p.axis == axisZ {
+		c = [3]dual{in(sd, p.x), constDual(p.y), in(td, p.z)}
+	}
+	v := nn.dual(c).scale(p.m)
+	for _, e := range p.extra {
+		c := [3]dual{in(td, e.x), constDual(e.y), in(sd, e.z)}
+		if p.axis == axisZ {
+			c = [3]dual{in(sd, e.x), constDual(e.y), in(td, e.z)}
+		}
+		v = v.add(sharedNoises.get(p.dimSeed, e.rl).dual(c).scale(e.m))
+	}
+	return v.add(constDual(p.b)).scale(p.amplifier()).sub(td)
+}
+
+// certifyBox is a box of positions with an enclosure of the error over it.
+type certifyBox struct {
+	t, s  interval
+	bound interval
+	// how much of the width of bound comes from each coordinate
+	widthT, widthS float64
+}
+
+func (p dfParams) newCertifyBox(nn *normalNoise, t, s interval) certifyBox {
+	// mean value form from the centre of the box
+	tc, sc := t.mid(), s.mid()
+	g0 := p.deviationDual(nn, point(tc), point(sc)).v
+	full := p.deviationDual(nn, t, s)
+	slopeT := full.d[0].mul(t.sub(point(tc)))
+	slopeS := full.d[1].mul(s.sub(point(sc)))
+	bound := g0.add(slopeT).add(slopeS)
+	// both forms contain the error, and so does their intersection
+	if bound.lo < full.v.hi && full.v.lo < bound.hi {
+		bound = bound.intersect(full.v)
+	}
+	return certifyBox{t, s, bound, slopeT.width(), slopeS.width()}
+}
+
+// certifyHeap orders boxes by the largest error they may contain.
+type certifyHeap []certifyBox
+
+func (h certifyHeap) Len() int           { return len(h) }
+func (h certifyHeap) Less(i, j int) bool { return h[i].bound.mag() > h[j].bound.mag() }
+func (h certifyHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *certifyHeap) Push(x any)        { *h = append(*h, x.(certifyBox)) }
+func (h *certifyHeap) Pop() any {
+	old := *h
+	b := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return b
+}
+
+// certifyError proves a bound on the error of p over its certified range,
+// both horizontal coordinates within it, evaluating at most maxBoxes boxes.
+// It fails for scales at which the game wraps the inputs of the noise.
+func (p dfParams) certifyError(nn *normalNoise, maxBoxes int) (certifiedError, error) {
+	var ce certifiedError
+	if c := p.inputRange(); !(c*secondScale < wrapLimit) {
+		return ce, fmt.Errorf("inputs up to %g wrap at xz_scale %g, which is not supported", c*secondScale, p.scale)
+	}
+	r := interval{-p.certifyRange(), p.certifyRange()}
+
+	// the error at the centre of a box is a value the function actually has
+	sample := func(b certifyBox) {
+		x, z := b.t.mid(), b.s.mid()
+		if p.axis == axisZ {
+			x, z = z, x
+		}
+		v := p.value(nn, x, z)
+		e := math.Abs(v - b.t.mid())
+		// written so that a NaN error is never smaller than the bound
+		if !(e <= ce.lower) {
+			ce.lower = e
+		}
+	}
+
+	h := &certifyHeap{p.newCertifyBox(nn, r, r)}
+	ce.boxes = 1
+	sample((*h)[0])
+	for {
+		top := (*h)[0]
+		done := top.bound.mag() <= ce.lower+math.Max(1, certifyTolerance*ce.lower)
+		if done || ce.boxes >= maxBoxes || math.IsNaN(ce.lower) {
+			ce.upper = math.Max(top.bound.mag(), ce.lower) + p.roundingMargin()
+			if math.IsNaN(top.bound.mag()) || math.IsNaN(ce.lower) {
+				ce.upper = math.NaN()
+			}
+			return ce, nil
+		}
+		heap.Pop(h)
+
+		// split along the coordinate contributing most to the width
+		var halves [2]certifyBox
+		if top.widthT >= top.widthS {
+			m := top.t.mid()
+			halves[0] = p.newCertifyBox(nn, interval{top.t.lo, m}, top.s)
+			halves[1] = p.newCertifyBox(nn, interval{m, top.t.hi}, top.s)
+		} else {
+			m := top.s.mid()
+			halves[0] = p.newCertifyBox(nn, top.t, interval{top.s.lo, m})
+			halves[1] = p.newCertifyBox(nn, top.t, interval{m, top.s.hi})
+		}
+		ce.boxes += 2
+		for _, b := range halves {
+			sample(b)
+			// boxes that cannot hold a larger error than one already found
+			// are dropped
+			if !(b.bound.mag() <= ce.lower) {
+				heap.Push(h, b)
+			}
+		}
+		if h.Len() == 0 {
+			ce.upper = ce.lower + p.roundingMargin()
+			return ce, nil
+		}
+	}
+}
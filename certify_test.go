@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestIntervalContainment(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	rnd := func() interval {
+		a, b := r.NormFloat64()*100, r.NormFloat64()*100
+		return interval{math.Min(a, b), math.Max(a, b)}
+	}
+	in := func(i interval) float64 {
+		return i.lo + r.Float64()*(i.hi-i.lo)
+	}
+	for i := 0; i < 100000; i++ {
+		a, b := rnd(), rnd()
+		x, y := in(a), in(b)
+		for _, c := range []struct {
+			name string
+			i    interval
+			v    float64
+		}{{"add", a.add(b), x + y}, {"sub", a.sub(b), x - y}, {"mul", a.mul(b), x * y}} {
+			if !c.i.contains(c.v) {
+				t.Fatalf("%s of %v and %v gives %v, which does not contain %v", c.name, a, b, c.i, c.v)
+			}
+		}
+
+		u := interval{r.Float64(), r.Float64()}
+		if u.lo > u.hi {
+			u.lo, u.hi = u.hi, u.lo
+		}
+		x = in(u)
+		if s := smoothStepInterval(u); !s.contains(smoothStep(x)) {
+			t.Fatalf("smoothStep over %v gives %v, which does not contain %v", u, s, smoothStep(x))
+		}
+		if s := smoothStepDerivInterval(u); !s.contains(30 * x * x * (x - 1) * (x - 1)) {
+			t.Fatalf("smoothStep derivative over %v gives %v", u, s)
+		}
+	}
+}
+
+// TestNoiseDual checks that the enclosures contain the noise sampled inside
+// boxes spanning several cells.
+func TestNoiseDual(t *testing.T) {
+	nn := seededNoise(3, "syph:c")
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 2000; i++ {
+		var box [3]interval
+		var c [3]dual
+		for j := range box {
+			// some boxes span more cells than are evaluated separately
+			w := 1.5
+			if i%4 == 0 {
+				w = 40
+			}
+			lo := r.Float64()*20 - 10
+			box[j] = interval{lo, lo + r.Float64()*w}
+			c[j] = constDual(0)
+			c[j].v = box[j]
+			c[j].d[j%2] = point(1)
+		}
+		d := nn.dual(c)
+		for k := 0; k < 20; k++ {
+			p := coord{
+				box[0].lo + r.Float64()*box[0].width(),
+				box[1].lo + r.Float64()*box[1].width(),
+				box[2].lo + r.Float64()*box[2].width(),
+			}
+			if v := nn.getValue(p); !d.v.contains(v) {
+				t.Fatalf("enclosure %v over %v does not contain %v at %v", d.v, box, v, p)
+			}
+		}
+	}
+}
+
+func TestCertifyError(t *testing.T) {
+	p := genFromDimSeed(context.Background(), 1, genOptions{}, firstOfEachAxis)
+	for _, d := range []dfParams{p.x, p.z} {
+		nn := seededNoise(d.dimSeed, d.rl)
+		ce, err := d.certifyError(nn, defaultCertifyBoxes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ce.boxes >= defaultCertifyBoxes {
+			t.Errorf("%s: no bound within the tolerance after %d boxes", d.axis, ce.boxes)
+		}
+		// the bound holds at positions within the certified range
+		for _, f := range []float64{-1, -0.1, -1e-3, 0, 1e-3, 0.1, 1} {
+			at := f * d.certifyRange()
+			x, z := at, 0.0
+			if d.axis == axisZ {
+				x, z = z, x
+			}
+			if e := math.Abs(d.value(nn, x, z) - at); !(e <= ce.upper) {
+				t.Errorf("%s: certified bound %g is below the error %g at %g", d.axis, ce.upper, e, at)
+			}
+		}
+		if !(ce.lower <= ce.upper && ce.upper <= ce.lower*(1+certifyTolerance)+d.roundingMargin()+1) {
+			t.Errorf("%s: bound %g is not within the tolerance of the error found, %g", d.axis, ce.upper, ce.lower)
+		}
+	}
+}
+
+func TestCertifyRange(t *testing.T) {
+	// at a coarse scale the fit only holds close to the origin, which is all
+	// that is certified
+	p := genFromDimSeed(context.Background(), 1, genOptions{scale: 1e-4}, firstOfEachAxis)
+	for _, d := range []dfParams{p.x, p.z} {
+		if !(d.reach > 0 && d.certifyRange() == d.reach) {
+			t.Fatalf("%s: certifying within %g with a usable range of %g", d.axis, d.certifyRange(), d.reach)
+		}
+		ce, err := d.certifyError(seededNoise(d.dimSeed, d.rl), defaultCertifyBoxes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ce.boxes >= defaultCertifyBoxes || !(ce.upper <= ce.lower*(1+certifyTolerance)+d.roundingMargin()+1) {
+			t.Errorf("%s: bound %g, %g found, after %d boxes", d.axis, ce.upper, ce.lower, ce.boxes)
+		}
+	}
+
+	// an unknown range is the world, over which large scales wrap
+	d := p.x
+	d.reach, d.scale, d.m = 0, 1, p.x.m*p.x.scale
+	if _, err := d.certifyError(seededNoise(d.dimSeed, d.rl), defaultCertifyBoxes); err == nil {
+		t.Error("no error certifying wrapped inputs")
+	}
+}
+
+func TestRoundingMargin(t *testing.T) {
+	p := genFromDimSeed(context.Background(), 1, genOptions{}, firstOfEachAxis)
+	for _, d := range []dfParams{p.x, p.z} {
+		// rounding the noise input alone moves the output by up to half the
+		// resolution, and the margin stays far below a block
+		margin := d.roundingMargin()
+		if !(d.resolution()/2 <= margin && margin < 0.1) {
+			t.Errorf("%s: rounding margin %g, resolution %g", d.axis, margin, d.resolution())
+		}
+	}
+}
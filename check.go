@@ -0,0 +1,147 @@
+package main
+
+import (
+	"archive/zip"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+)
+
+// checkDistances are the distances from the origin along each axis and the
+// diagonals at which check evaluates the functions. They are small enough for
+// the error of a correct pack to stay well below the default tolerance, while
+// a pack made for another seed is off by far more.
+var checkDistances = []int64{0, 1, 16, 100, 1000, 4096}
+
+// packMismatch describes the worst deviation check found for a function.
+type packMismatch struct {
+	fn        string
+	at        intCoord
+	got, want float64
+}
+
+func (m packMismatch) deviation() float64 {
+	return math.Abs(m.got - m.want)
+}
+
+func (m packMismatch) String() string {
+	return fmt.Sprintf("%s at %d,%d,%d is %g, expected %g", m.fn, m.at.x, m.at.y, m.at.z, m.got, m.want)
+}
+
+// checkPack evaluates the x and z functions of namespace ns in fsys for the
+// seed and returns the worst deviation from the coordinates for each.
+func checkPack(fsys fs.FS, seed int64, ns string) ([2]packMismatch, error) {
+	var worst [2]packMismatch
+	l := newDfLoader(fsys, seed)
+	for i, name := range [2]string{"x", "z"} {
+		f, err := l.load(ns + ":" + name)
+		if err != nil {
+			return worst, err
+		}
+		worst[i].fn = ns + ":" + name
+		first := true
+		for _, d := range checkDistances {
+			for _, dx := range []int64{-d, 0, d} {
+				for _, dz := range []int64{-d, 0, d} {
+					for _, y := range []int64{0, 100} {
+						c := intCoord{dx, y, dz}
+						m := packMismatch{worst[i].fn, c, f.compute(c), float64(dx)}
+						if i == 1 {
+							m.want = float64(dz)
+						}
+						// NaN counts as the worst deviation
+						if first || !(m.deviation() <= worst[i].deviation()) {
+							worst[i] = m
+							first = false
+						}
+					}
+				}
+			}
+		}
+	}
+	return worst, nil
+}
+
+// openPack opens a data pack folder or zip file. close releases the zip file.
+func openPack(name string) (fsys fs.FS, close func() error, err error) {
+	st, err := os.Stat(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if st.IsDir() {
+		return os.DirFS(name), func() error { return nil }, nil
+	}
+	z, err := zip.OpenReader(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return z, z.Close, nil
+}
+
+// parseInterspersed parses flags that may follow positional arguments and
+// returns the positional arguments.
+func parseInterspersed(flags *flag.FlagSet, args []string) []string {
+	var pos []string
+	for {
+		flags.Parse(args)
+		rest := flags.Args()
+		if len(rest) == 0 {
+			return pos
+		}
+		// everything after -- is positional
+		if n := len(args) - len(rest); n > 0 && args[n-1] == "--" {
+			return append(pos, rest...)
+		}
+		pos = append(pos, rest[0])
+		args = rest[1:]
+	}
+}
+
+func checkMain(args []string) {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	seed := flags.Int64("seed", 0, "dimension `seed` of the world the pack is used in (required)")
+	ns := flags.String("namespace", namespace, "`namespace` of the coordinate functions")
+	tolerance := flags.Float64("tolerance", 1, "largest accepted deviation in `blocks`")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: dfcoord check <pack folder or zip> -seed <dimension seed> [flags]")
+		fmt.Fprintln(flags.Output(), "Checks that the coordinate functions of a data pack were made for a seed.")
+		flags.PrintDefaults()
+	}
+	pos := parseInterspersed(flags, args)
+
+	if len(pos) != 1 || !isFlagSet(flags, "seed") {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	fsys, closePack, err := openPack(pos[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer closePack()
+
+	worst, err := checkPack(packRoot(fsys), *seed, *ns)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ok := true
+	for _, m := range worst {
+		if !(m.deviation() <= *tolerance) {
+			ok = false
+			fmt.Printf("mismatch: %s\n", m)
+		} else {
+			fmt.Printf("%s deviates by at most %g blocks\n", m.fn, m.deviation())
+		}
+	}
+	if !ok {
+		fmt.Printf("%s was not made for seed %d\n", pos[0], *seed)
+		// deferred functions do not run on exit
+		closePack()
+		os.Exit(1)
+	}
+	fmt.Printf("%s matches seed %d\n", pos[0], *seed)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+// testPack returns the files of a pack for seed 1, for which the first
+// candidates of both axes are accurate.
+func testPack(t *testing.T) fstest.MapFS {
+	p := genFromDimSeed(context.Background(), 1, genOptions{}, firstOfEachAxis)
+	if !p.okx || !p.okz {
+		t.Fatal("no parameters found")
+	}
+	files := fstest.MapFS{}
+	for name, d := range map[string]dfParams{"x": p.x, "z": p.z} {
+		b, err := json.Marshal(d.function())
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[namespace+"/worldgen/density_function/"+name+".json"] = &fstest.MapFile{Data: b}
+		ns, id := split(d.rl)
+		files[ns+"/worldgen/noise/"+id+".json"] = &fstest.MapFile{Data: []byte(noiseFile)}
+	}
+	return files
+}
+
+func TestCheckPack(t *testing.T) {
+	files := testPack(t)
+	worst, err := checkPack(files, 1, namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range worst {
+		if !(m.deviation() <= 1) {
+			t.Errorf("pack does not match its own seed: %s", m)
+		}
+	}
+
+	worst, err = checkPack(files, 2, namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range worst {
+		if m.deviation() <= 1 {
+			t.Errorf("pack matches another seed, %s deviates by %g", m.fn, m.deviation())
+		}
+	}
+
+	if _, err := checkPack(files, 1, "other"); err == nil {
+		t.Error("no error for a missing namespace")
+	}
+}
+
+func TestCheckPackZip(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "pack.zip")
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(f)
+	for path, file := range testPack(t) {
+		fw, err := w.Create("data/" + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(file.Data)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	fsys, closePack, err := openPack(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closePack()
+	if _, err := checkPack(packRoot(fsys), 1, namespace); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseInterspersed(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	seed := flags.Int64("seed", 0, "")
+	pos := parseInterspersed(flags, []string{"a", "-seed", "5", "b", "--", "-c", "-seed", "6"})
+	if *seed != 5 || !reflect.DeepEqual(pos, []string{"a", "b", "-c", "-seed", "6"}) {
+		t.Errorf("parsed seed %d and %q", *seed, pos)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"math"
+	"strings"
+)
+
+// A fitted function deviates from the coordinate by a curvature term that
+// grows away from its fit point, and different candidates curve in different
+// directions. Adding a few candidates of the same axis with weights w_i that
+// sum to 1, plus a constant c, keeps the slope while much of the curvature
+// cancels. The weights and c are chosen by least squares on the errors of the
+// candidates at positions along the axis, which is well conditioned as the
+// errors are in blocks, unlike the noise values they come from.
+
+// dfTerm is a noise term of a function: m times the noise rl shifted by x, y,
+// z, at the xz_scale of the function.
+type dfTerm struct {
+	rl      string
+	x, y, z float64
+	m       float64
+}
+
+// term returns the first noise term of p.
+func (p dfParams) term() dfTerm {
+	return dfTerm{p.rl, p.x, p.y, p.z, p.m}
+}
+
+// noises returns the distinct noises p uses, the first one first.
+func (p dfParams) noises() []string {
+	rls := []string{p.rl}
+	for _, t := range p.extra {
+		seen := false
+		for _, rl := range rls {
+			seen = seen || rl == t.rl
+		}
+		if !seen {
+			rls = append(rls, t.rl)
+		}
+	}
+	return rls
+}
+
+// describe names the noises of the terms of p.
+func (p dfParams) describe() string {
+	names := []string{p.rl}
+	for _, t := range p.extra {
+		names = append(names, t.rl)
+	}
+	return strings.Join(names, " + ")
+}
+
+const (
+	// combinePool is how many candidates of each axis combinations are
+	// chosen from
+	combinePool = 8
+	// combineSamples is how many positions on each side of the origin the
+	// weights are fitted at, spaced geometrically from combineNear to the
+	// usable range
+	combineSamples = 64
+	// combineNear is the distance in blocks within which the fit weighs the
+	// absolute rather than the relative error
+	combineNear = 1000.0
+	// maxCombine is the largest number of candidates combined
+	maxCombine = 3
+)
+
+// candidatePool holds candidates of each axis in the order they were found.
+type candidatePool struct {
+	x, z []dfParams
+	seen map[candidateKey]bool // candidates added with add
+}
+
+// collectCandidates returns a reduce callback for genFromDimSeed collecting
+// the first n candidates of each axis.
+func collectCandidates(n int) func(a candidatePool, first bool, d dfParams) (candidatePool, bool) {
+	return func(a candidatePool, first bool, d dfParams) (candidatePool, bool) {
+		if d.axis == axisX && len(a.x) < n {
+			a.x = append(a.x, d)
+		}
+		if d.axis == axisZ && len(a.z) < n {
+			a.z = append(a.z, d)
+		}
+		return a, len(a.x) < n || len(a.z) < n
+	}
+}
+
+// combine fits weights for candidates of the same axis and xz_scale, over the
+// positions all of them are usable at, and returns the weighted sum.
+func combine(cs []dfParams) (dfParams, bool) {
+	k := len(cs)
+	r := worldBorder
+	for _, c := range cs {
+		if c.reach > 0 {
+			r = math.Min(r, c.reach)
+		}
+	}
+
+	// the errors are weighted by the inverse distance, so that the functions
+	// stay accurate near the origin where they are used the most
+	ts := []float64{0}
+	for j := 0; j < combineSamples; j++ {
+		d := combineNear * math.Pow(r/combineNear, float64(j)/(combineSamples-1))
+		ts = append(ts, d, -d)
+	}
+	e := make([][]float64, k)
+	for i, c := range cs {
+		nn := sharedNoises.get(c.dimSeed, c.rl)
+		e[i] = make([]float64, len(ts))
+		for j, t := range ts {
+			v := c.value(nn, t, 0)
+			if c.axis == axisZ {
+				v = c.value(nn, 0, t)
+			}
+			e[i][j] = (v - t) / (math.Abs(t) + combineNear)
+		}
+	}
+
+	// with w_k = 1 - sum of the others the error is
+	// e_k + sum w_i (e_i - e_k) + c, linear in the remaining unknowns
+	col := func(i, j int) float64 {
+		if i == k-1 {
+			return 1 / (math.Abs(ts[j]) + combineNear)
+		}
+		return e[i][j] - e[k-1][j]
+	}
+	ata := make([][]float64, k)
+	atb := make([]float64, k)
+	for i := range ata {
+		ata[i] = make([]float64, k)
+		for j := range ts {
+			for l := range ata[i] {
+				ata[i][l] += col(i, j) * col(l, j)
+			}
+			atb[i] -= col(i, j) * e[k-1][j]
+		}
+	}
+	u, ok := solveLinear(ata, atb)
+	if !ok {
+		return dfParams{}, false
+	}
+
+	w := make([]float64, k)
+	w[k-1] = 1
+	for i := 0; i < k-1; i++ {
+		w[i] = u[i]
+		w[k-1] -= u[i]
+	}
+
+	p := cs[0]
+	p.m = w[0] * cs[0].m
+	p.b = u[k-1] / p.amplifier()
+	p.extra = nil
+	for i, c := range cs {
+		p.b += w[i] * c.b
+		if i > 0 {
+			t := c.term()
+			t.m *= w[i]
+			p.extra = append(p.extra, t)
+		}
+	}
+	p.cert = 0
+	if !validateParams(p) {
+		return dfParams{}, false
+	}
+	p.reach = r
+	p.err = p.estimateError(sharedNoises.get(p.dimSeed, p.rl))
+	// the weights are only fitted on the axis, and members of unknown reach
+	// may leave their cells, so the reach is checked across the axis too
+	for !p.accurateAcross(r) {
+		if r <= combineNear {
+			return dfParams{}, false
+		}
+		r /= 2
+	}
+	p.reach = r
+	return p, true
+}
+
+// accurateAcross reports whether p stays within its estimated error at the
+// probe distances up to r along the axis, moved by r to either side of it.
+func (p dfParams) accurateAcross(r float64) bool {
+	nn := sharedNoises.get(p.dimSeed, p.rl)
+	for _, d := range append([]float64{0}, probeDistances...) {
+		if d > r {
+			break
+		}
+		for _, t := range [2]float64{d, -d} {
+			for _, s := range [2]float64{r, -r} {
+				x, z := t, s
+				if p.axis == axisZ {
+					x, z = s, t
+				}
+				// written so that a NaN value is never accurate
+				if !(math.Abs(p.value(nn, x, z)-t) <= p.err) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// bestCombination combines every choice of k of the candidates cs and returns
+// the result with the lowest estimated error. The best single candidate is
+// returned if no combination improves on it.
+func bestCombination(cs []dfParams, k int) dfParams {
+	best := cs[0]
+	for _, c := range cs[1:] {
+		if c.err < best.err || !isNumber(best.err) {
+			best = c
+		}
+	}
+	if k > len(cs) {
+		k = len(cs)
+	}
+
+	choice := make([]dfParams, 0, k)
+	var try func(from int)
+	try = func(from int) {
+		if len(choice) == k {
+			p, ok := combine(choice)
+			if ok && (p.err < best.err || !isNumber(best.err)) {
+				best = p
+			}
+			return
+		}
+		for i := from; i < len(cs); i++ {
+			choice = append(choice, cs[i])
+			try(i + 1)
+			choice = choice[:len(choice)-1]
+		}
+	}
+	if k > 1 {
+		try(0)
+	}
+	return best
+}
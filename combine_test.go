@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSolveLinear(t *testing.T) {
+	a := [][]float64{{0, 2, 1}, {1, 1, 1}, {2, 0, -1}}
+	x, ok := solveLinear(a, []float64{7, 6, -1})
+	if !ok {
+		t.Fatal("regular system reported singular")
+	}
+	for i, want := range []float64{1, 2, 3} {
+		if math.Abs(x[i]-want) > 1e-12 {
+			t.Errorf("x[%d] = %g, expected %g", i, x[i], want)
+		}
+	}
+	if _, ok := solveLinear([][]float64{{1, 2}, {2, 4}}, []float64{1, 2}); ok {
+		t.Error("singular system solved")
+	}
+}
+
+func TestCombine(t *testing.T) {
+	const seed = 42
+	pool := genFromDimSeed(context.Background(), seed, genOptions{scale: 2e-10}, collectCandidates(combinePool))
+	files := fstest.MapFS{}
+	for _, cs := range [][]dfParams{pool.x, pool.z} {
+		single := bestCombination(cs, 1)
+		p := bestCombination(cs, 3)
+		if len(p.extra) != 2 {
+			t.Fatalf("%s: combined %d candidates, expected 3", p.axis, len(p.extra)+1)
+		}
+		if !(p.err < single.err/10) {
+			t.Errorf("%s: combined error %g, best single candidate %g", p.axis, p.err, single.err)
+		}
+
+		b, err := json.Marshal(p.function())
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[namespace+"/worldgen/density_function/"+p.axis.String()+".json"] = &fstest.MapFile{Data: b}
+		for _, rl := range p.noises() {
+			ns, id := split(rl)
+			files[ns+"/worldgen/noise/"+id+".json"] = &fstest.MapFile{Data: []byte(noiseFile)}
+		}
+
+		f, err := newDfLoader(files, seed).load(namespace + ":" + p.axis.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		nn := seededNoise(seed, p.rl)
+		for _, v := range []int64{0, 1000, -123456, 2.9e7} {
+			c := intCoord{v, 64, v}
+			if got, want := f.compute(c), p.value(nn, float64(v), float64(v)); got != want {
+				t.Errorf("%s at %v: evaluated %g, combined function gives %g", p.axis, c, got, want)
+			}
+		}
+
+		// the weights are fitted on the axis only, but within reach every
+		// member, and so their sum, is independent of the other coordinate
+		r := p.reach
+		if !(r >= 1e7) {
+			t.Fatalf("%s: combination usable within %g blocks, expected most of the world", p.axis, r)
+		}
+		for _, v := range []float64{0, 1000, -123456, r, -r} {
+			for _, s := range []float64{r, -r} {
+				on, off := p.value(nn, v, 0), p.value(nn, v, s)
+				if p.axis == axisZ {
+					on, off = p.value(nn, 0, v), p.value(nn, s, v)
+				}
+				if !(math.Abs(off-on) <= 1e-6) {
+					t.Errorf("%s at %g, %g across: %g, on the axis %g", p.axis, v, s, off, on)
+				}
+			}
+		}
+	}
+
+	worst, err := checkPack(files, seed, namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range worst {
+		if !(m.deviation() <= 0.1) {
+			t.Errorf("combined functions are inaccurate near the origin: %s", m)
+		}
+	}
+}
+
+func TestCombineUnknownReach(t *testing.T) {
+	pool := genFromDimSeed(context.Background(), 42, genOptions{scale: 2e-10}, collectCandidates(combinePool))
+	var short, long *dfParams
+	for i := range pool.x {
+		c := &pool.x[i]
+		if c.reach < 2.9e7 && short == nil {
+			short = c
+		}
+		if c.reach > worldBorder && long == nil {
+			long = c
+		}
+	}
+	if short == nil || long == nil {
+		t.Fatal("no candidates of short and long reach")
+	}
+	if p, ok := combine([]dfParams{*long, *short}); !ok || p.reach > short.reach {
+		t.Fatalf("combination usable within %g blocks, %v, beyond the %g of a member", p.reach, ok, short.reach)
+	}
+
+	// as in parameters saved without their reach, the combination leaves the
+	// cells of the member across the axis, which the weights do not show
+	unknown := *short
+	unknown.reach = 0
+	p, ok := combine([]dfParams{*long, unknown})
+	if !ok {
+		t.Fatal("no combination")
+	}
+	if !(p.reach <= short.reach) {
+		t.Errorf("combination usable within %g blocks, beyond the %g of a member", p.reach, short.reach)
+	}
+	if !p.accurateAcross(p.reach) {
+		t.Errorf("combination inaccurate within its reach of %g", p.reach)
+	}
+}
@@ -0,0 +1,505 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"path"
+	"sort"
+	"strings"
+)
+
+// densityFunction is a node of a density function graph, evaluated the way
+// the game evaluates it at a single block position.
+type densityFunction interface {
+	compute(c intCoord) float64
+}
+
+type dfConstant float64
+
+func (f dfConstant) compute(c intCoord) float64 {
+	return float64(f)
+}
+
+type dfAdd struct {
+	a, b densityFunction
+}
+
+func (f dfAdd) compute(c intCoord) float64 {
+	return f.a.compute(c) + f.b.compute(c)
+}
+
+type dfMul struct {
+	a, b densityFunction
+}
+
+func (f dfMul) compute(c intCoord) float64 {
+	// the game skips the second argument if the first is zero
+	v := f.a.compute(c)
+	if v == 0 {
+		return 0
+	}
+	return v * f.b.compute(c)
+}
+
+// dfMarker stands in for caching nodes, which do not change the value outside
+// of chunk generation.
+type dfMarker struct {
+	arg densityFunction
+}
+
+func (f dfMarker) compute(c intCoord) float64 {
+	return f.arg.compute(c)
+}
+
+type dfAbs struct {
+	arg densityFunction
+}
+
+func (f dfAbs) compute(c intCoord) float64 {
+	return math.Abs(f.arg.compute(c))
+}
+
+type dfSquare struct {
+	arg densityFunction
+}
+
+func (f dfSquare) compute(c intCoord) float64 {
+	v := f.arg.compute(c)
+	return v * v
+}
+
+type dfClamp struct {
+	arg      densityFunction
+	min, max float64
+}
+
+func (f dfClamp) compute(c intCoord) float64 {
+	v := f.arg.compute(c)
+	if v < f.min {
+		return f.min
+	}
+	return math.Min(v, f.max)
+}
+
+type dfMin struct {
+	a, b densityFunction
+}
+
+func (f dfMin) compute(c intCoord) float64 {
+	return math.Min(f.a.compute(c), f.b.compute(c))
+}
+
+type dfMax struct {
+	a, b densityFunction
+}
+
+func (f dfMax) compute(c intCoord) float64 {
+	return math.Max(f.a.compute(c), f.b.compute(c))
+}
+
+type dfYClampedGradient struct {
+	fromY, toY         float64
+	fromValue, toValue float64
+}
+
+func (f dfYClampedGradient) compute(c intCoord) float64 {
+	t := invLerp(float64(c.y), f.fromY, f.toY)
+	if t < 0 {
+		return f.fromValue
+	}
+	if t > 1 {
+		return f.toValue
+	}
+	return lerp(t, f.fromValue, f.toValue)
+}
+
+type dfRangeChoice struct {
+	input               densityFunction
+	min, max            float64 // min is inclusive, max exclusive
+	inRange, outOfRange densityFunction
+}
+
+func (f dfRangeChoice) compute(c intCoord) float64 {
+	v := f.input.compute(c)
+	if v >= f.min && v < f.max {
+		return f.inRange.compute(c)
+	}
+	return f.outOfRange.compute(c)
+}
+
+type dfSpline struct {
+	s spline
+}
+
+func (f dfSpline) compute(c intCoord) float64 {
+	return float64(f.s.apply(c))
+}
+
+// dfInterpolated interpolates its argument between the corners of the noise
+// cell containing the position, as the game does during chunk generation. It
+// always interpolates, also at positions the game would evaluate directly.
+type dfInterpolated struct {
+	arg                   densityFunction
+	cellWidth, cellHeight int64
+}
+
+func (f dfInterpolated) compute(c intCoord) float64 {
+	floorDiv := func(a, b int64) int64 {
+		q := a / b
+		if a%b != 0 && (a < 0) != (b < 0) {
+			q--
+		}
+		return q
+	}
+	x0 := floorDiv(c.x, f.cellWidth) * f.cellWidth
+	y0 := floorDiv(c.y, f.cellHeight) * f.cellHeight
+	z0 := floorDiv(c.z, f.cellWidth) * f.cellWidth
+	var v [8]float64
+	for i := range v {
+		corner := intCoord{x0, y0, z0}
+		if i&1 != 0 {
+			corner.x += f.cellWidth
+		}
+		if i&2 != 0 {
+			corner.y += f.cellHeight
+		}
+		if i&4 != 0 {
+			corner.z += f.cellWidth
+		}
+		v[i] = f.arg.compute(corner)
+	}
+	dx := float64(c.x-x0) / float64(f.cellWidth)
+	dy := float64(c.y-y0) / float64(f.cellHeight)
+	dz := float64(c.z-z0) / float64(f.cellWidth)
+	return lerp3(dx, dy, dz, v[0], v[1], v[2], v[3], v[4], v[5], v[6], v[7])
+}
+
+type dfShiftedNoise struct {
+	nn                     *normalNoise
+	xzScale, yScale        float64
+	shiftX, shiftY, shiftZ densityFunction
+}
+
+func (f dfShiftedNoise) compute(c intCoord) float64 {
+	x := float64(c.x)*f.xzScale + f.shiftX.compute(c)
+	y := float64(c.y)*f.yScale + f.shiftY.compute(c)
+	z := float64(c.z)*f.xzScale + f.shiftZ.compute(c)
+	return f.nn.getValue(coord{x, y, z})
+}
+
+// spline is a cubic spline as used by the game, which evaluates splines in
+// single precision.
+type spline interface {
+	apply(c intCoord) float32
+}
+
+type splineConstant float32
+
+func (s splineConstant) apply(c intCoord) float32 {
+	return float32(s)
+}
+
+type splineMultipoint struct {
+	coordinate  densityFunction
+	locations   []float32
+	values      []spline
+	derivatives []float32
+}
+
+func (s splineMultipoint) apply(c intCoord) float32 {
+	f := float32(s.coordinate.compute(c))
+
+	// index of the last location not above f, -1 if there is none
+	i := sort.Search(len(s.locations), func(i int) bool { return f < s.locations[i] }) - 1
+	last := len(s.locations) - 1
+
+	linearExtend := func(i int) float32 {
+		v := s.values[i].apply(c)
+		d := s.derivatives[i]
+		if d == 0 {
+			return v
+		}
+		return v + float32(d*(f-s.locations[i]))
+	}
+	if i < 0 {
+		return linearExtend(0)
+	}
+	if i == last {
+		return linearExtend(last)
+	}
+
+	lo, hi := s.locations[i], s.locations[i+1]
+	k := (f - lo) / (hi - lo)
+	v0, v1 := s.values[i].apply(c), s.values[i+1].apply(c)
+	p := float32(s.derivatives[i]*(hi-lo)) - (v1 - v0)
+	q := float32(-s.derivatives[i+1]*(hi-lo)) + (v1 - v0)
+	lerp32 := func(t, a, b float32) float32 {
+		return a + float32(t*(b-a))
+	}
+	return lerp32(k, v0, v1) + float32(float32(k*(1-k))*lerp32(k, p, q))
+}
+
+// dfLoader reads density functions and noises from the worldgen folders of a
+// data pack, constructing noises for a given seed.
+type dfLoader struct {
+	fsys    fs.FS // contains one folder per namespace
+	seed    int64
+	fns     map[string]densityFunction
+	noises  map[string]*normalNoise
+	loading map[string]bool
+
+	// size of the noise cells used by interpolated, in blocks
+	cellWidth, cellHeight int64
+}
+
+// newDfLoader returns a loader using the overworld's noise cell size.
+func newDfLoader(fsys fs.FS, seed int64) *dfLoader {
+	return &dfLoader{fsys, seed, make(map[string]densityFunction), make(map[string]*normalNoise), make(map[string]bool), 4, 8}
+}
+
+// packRoot returns the data folder of a data pack, or fsys itself if it has
+// none, as is the case for the folders written by this program.
+func packRoot(fsys fs.FS) fs.FS {
+	if st, err := fs.Stat(fsys, "data"); err == nil && st.IsDir() {
+		if sub, err := fs.Sub(fsys, "data"); err == nil {
+			return sub
+		}
+	}
+	return fsys
+}
+
+// qualify adds the default namespace to a resource location if it has none.
+func qualify(rl string) string {
+	if strings.Contains(rl, ":") {
+		return rl
+	}
+	return "minecraft:" + rl
+}
+
+func (l *dfLoader) readJSON(rl string, kind string, v any) error {
+	ns, id := split(qualify(rl))
+	b, err := fs.ReadFile(l.fsys, path.Join(ns, "worldgen", kind, id+".json"))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("%s %s: %w", kind, rl, err)
+	}
+	return nil
+}
+
+// load returns the density function stored under rl.
+func (l *dfLoader) load(rl string) (densityFunction, error) {
+	rl = qualify(rl)
+	if f, ok := l.fns[rl]; ok {
+		return f, nil
+	}
+	if l.loading[rl] {
+		return nil, fmt.Errorf("density function %s references itself", rl)
+	}
+	l.loading[rl] = true
+	defer delete(l.loading, rl)
+
+	var raw json.RawMessage
+	if err := l.readJSON(rl, "density_function", &raw); err != nil {
+		return nil, err
+	}
+	f, err := l.parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("density function %s: %w", rl, err)
+	}
+	l.fns[rl] = f
+	return f, nil
+}
+
+// noise returns the noise stored under rl. Only the noise parameters written
+// by this program are supported.
+func (l *dfLoader) noise(rl string) (*normalNoise, error) {
+	rl = qualify(rl)
+	if nn, ok := l.noises[rl]; ok {
+		return nn, nil
+	}
+	var def struct {
+		FirstOctave int       `json:"firstOctave"`
+		Amplitudes  []float64 `json:"amplitudes"`
+	}
+	if err := l.readJSON(rl, "noise", &def); err != nil {
+		return nil, err
+	}
+	if def.FirstOctave != 0 || len(def.Amplitudes) != 1 || def.Amplitudes[0] != 1 {
+		return nil, fmt.Errorf("noise %s: only a single octave 0 of amplitude 1 is supported", rl)
+	}
+	nn := sharedNoises.get(l.seed, rl)
+	l.noises[rl] = nn
+	return nn, nil
+}
+
+// isNull reports whether raw is the JSON null, which encoding/json decodes
+// into numbers and strings without an error, leaving them unchanged.
+func isNull(raw json.RawMessage) bool {
+	return bytes.Equal(bytes.TrimSpace(raw), []byte("null"))
+}
+
+// parse builds a density function from its JSON form, which is either a
+// constant, a reference to another density function or an object.
+func (l *dfLoader) parse(raw json.RawMessage) (densityFunction, error) {
+	if isNull(raw) {
+		return nil, errors.New("expected a number, string or object, got null")
+	}
+	var v float64
+	if err := json.Unmarshal(raw, &v); err == nil {
+		return dfConstant(v), nil
+	}
+	var rl string
+	if err := json.Unmarshal(raw, &rl); err == nil {
+		return l.load(rl)
+	}
+
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return nil, errors.New("expected a number, string or object")
+	}
+	var typ string
+	if err := json.Unmarshal(obj["type"], &typ); err != nil {
+		return nil, errors.New("missing type")
+	}
+
+	// field helpers, the first error is kept and returned below
+	var err error
+	fn := func(name string) densityFunction {
+		if err != nil {
+			return nil
+		}
+		r, ok := obj[name]
+		if !ok {
+			err = fmt.Errorf("%s: missing field %s", typ, name)
+			return nil
+		}
+		var f densityFunction
+		f, err = l.parse(r)
+		return f
+	}
+	num := func(name string) float64 {
+		if err != nil {
+			return 0
+		}
+		var v float64
+		if e := json.Unmarshal(obj[name], &v); e != nil || isNull(obj[name]) {
+			err = fmt.Errorf("%s: field %s must be a number", typ, name)
+		}
+		return v
+	}
+	str := func(name string) string {
+		if err != nil {
+			return ""
+		}
+		var s string
+		if e := json.Unmarshal(obj[name], &s); e != nil || isNull(obj[name]) {
+			err = fmt.Errorf("%s: field %s must be a string", typ, name)
+		}
+		return s
+	}
+
+	var f densityFunction
+	switch qualify(typ) {
+	case "minecraft:constant":
+		f = dfConstant(num("argument"))
+	case "minecraft:add":
+		f = dfAdd{fn("argument1"), fn("argument2")}
+	case "minecraft:mul":
+		f = dfMul{fn("argument1"), fn("argument2")}
+	case "minecraft:flat_cache", "minecraft:cache_2d", "minecraft:cache_once", "minecraft:cache_all_in_cell":
+		f = dfMarker{fn("argument")}
+	case "minecraft:abs":
+		f = dfAbs{fn("argument")}
+	case "minecraft:square":
+		f = dfSquare{fn("argument")}
+	case "minecraft:clamp":
+		f = dfClamp{fn("input"), num("min"), num("max")}
+	case "minecraft:min":
+		f = dfMin{fn("argument1"), fn("argument2")}
+	case "minecraft:max":
+		f = dfMax{fn("argument1"), fn("argument2")}
+	case "minecraft:y_clamped_gradient":
+		f = dfYClampedGradient{num("from_y"), num("to_y"), num("from_value"), num("to_value")}
+	case "minecraft:range_choice":
+		f = dfRangeChoice{fn("input"), num("min_inclusive"), num("max_exclusive"), fn("when_in_range"), fn("when_out_of_range")}
+	case "minecraft:spline":
+		var s spline
+		if err == nil {
+			s, err = l.parseSpline(obj["spline"])
+		}
+		f = dfSpline{s}
+	case "minecraft:interpolated":
+		f = dfInterpolated{fn("argument"), l.cellWidth, l.cellHeight}
+	case "minecraft:shifted_noise":
+		var nn *normalNoise
+		if rl := str("noise"); err == nil {
+			nn, err = l.noise(rl)
+		}
+		f = dfShiftedNoise{nn, num("xz_scale"), num("y_scale"), fn("shift_x"), fn("shift_y"), fn("shift_z")}
+	case "minecraft:old_blended_noise":
+		f = seededBlendedNoise(l.seed, num("xz_scale"), num("y_scale"), num("xz_factor"), num("y_factor"), num("smear_scale_multiplier"))
+	case "minecraft:end_islands":
+		f = newEndIslands(l.seed)
+	default:
+		return nil, fmt.Errorf("unsupported type %s", typ)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// parseSpline builds a spline from its JSON form, which is either a constant
+// or an object with a coordinate and a list of points.
+func (l *dfLoader) parseSpline(raw json.RawMessage) (spline, error) {
+	if isNull(raw) {
+		return nil, errors.New("spline: expected a number or object, got null")
+	}
+	var v float32
+	if err := json.Unmarshal(raw, &v); err == nil {
+		return splineConstant(v), nil
+	}
+
+	var obj struct {
+		Coordinate json.RawMessage `json:"coordinate"`
+		Points     []struct {
+			Location   float32         `json:"location"`
+			Value      json.RawMessage `json:"value"`
+			Derivative float32         `json:"derivative"`
+		} `json:"points"`
+	}
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return nil, fmt.Errorf("spline: %w", err)
+	}
+	if obj.Coordinate == nil {
+		return nil, errors.New("spline: missing field coordinate")
+	}
+	if len(obj.Points) == 0 {
+		return nil, errors.New("spline: no points")
+	}
+
+	coordinate, err := l.parse(obj.Coordinate)
+	if err != nil {
+		return nil, err
+	}
+	s := splineMultipoint{coordinate: coordinate}
+	for i, p := range obj.Points {
+		if i > 0 && !(p.Location > s.locations[i-1]) {
+			return nil, errors.New("spline: point locations must be ascending")
+		}
+		v, err := l.parseSpline(p.Value)
+		if err != nil {
+			return nil, err
+		}
+		s.locations = append(s.locations, p.Location)
+		s.values = append(s.values, v)
+		s.derivatives = append(s.derivatives, p.Derivative)
+	}
+	return s, nil
+}
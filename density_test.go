@@ -0,0 +1,244 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// testFunctions returns a pack holding the density functions syph:<name> for
+// each of fns, the noise syph:n and the noise syph:octaves, which has more
+// octaves than the evaluator supports.
+func testFunctions(fns map[string]string) fstest.MapFS {
+	files := fstest.MapFS{
+		"syph/worldgen/noise/n.json":       {Data: []byte(noiseFile)},
+		"syph/worldgen/noise/octaves.json": {Data: []byte(`{"firstOctave": -2, "amplitudes": [1, 1]}`)},
+	}
+	for name, fn := range fns {
+		files["syph/worldgen/density_function/"+name+".json"] = &fstest.MapFile{Data: []byte(fn)}
+	}
+	return files
+}
+
+func TestParse(t *testing.T) {
+	const seed = 5
+	c := intCoord{6, -3, 10}
+	nn := sharedNoises.get(seed, "syph:n")
+	for _, tc := range []struct {
+		name, fn string
+		want     float64
+	}{
+		{"number", `2.5`, 2.5},
+		{"reference", `"syph:number"`, 2.5},
+		{"constant", `{"type": "minecraft:constant", "argument": -4}`, -4},
+		{"unqualified", `{"type": "constant", "argument": 3}`, 3},
+		{"add", `{"type": "minecraft:add", "argument1": 1, "argument2": "syph:number"}`, 3.5},
+		{"mul", `{"type": "minecraft:mul", "argument1": 3, "argument2": -2}`, -6},
+		{"flat_cache", `{"type": "minecraft:flat_cache", "argument": 7}`, 7},
+		{"cache_2d", `{"type": "minecraft:cache_2d", "argument": 7}`, 7},
+		{"cache_once", `{"type": "minecraft:cache_once", "argument": 7}`, 7},
+		{"cache_all_in_cell", `{"type": "minecraft:cache_all_in_cell", "argument": 7}`, 7},
+		{"abs", `{"type": "minecraft:abs", "argument": -1.5}`, 1.5},
+		{"square", `{"type": "minecraft:square", "argument": -3}`, 9},
+		{"clamp", `{"type": "minecraft:clamp", "input": 5, "min": -1, "max": 2}`, 2},
+		{"min", `{"type": "minecraft:min", "argument1": 5, "argument2": -1}`, -1},
+		{"max", `{"type": "minecraft:max", "argument1": 5, "argument2": -1}`, 5},
+		{"gradient", `{"type": "minecraft:y_clamped_gradient", "from_y": -5, "to_y": 5, "from_value": 0, "to_value": 1}`, 0.2},
+		{"range_choice", `{"type": "minecraft:range_choice", "input": 1, "min_inclusive": 0, "max_exclusive": 1,
+			"when_in_range": 10, "when_out_of_range": 20}`, 20},
+		{"spline", `{"type": "minecraft:spline", "spline": {"coordinate": 0.5,
+			"points": [{"location": 0, "value": 0, "derivative": 0}, {"location": 1, "value": 2, "derivative": 0}]}}`, 1},
+		{"nested_spline", `{"type": "minecraft:spline", "spline": {"coordinate": -1,
+			"points": [{"location": 0, "value": {"coordinate": 3, "points": [{"location": 0, "value": 0, "derivative": 2}]}, "derivative": 0}]}}`, 6},
+		{"interpolated", `{"type": "minecraft:interpolated", "argument": 4}`, 4},
+		{"shifted_noise", `{"type": "minecraft:shifted_noise", "noise": "syph:n", "xz_scale": 0.5, "y_scale": 0.25,
+			"shift_x": 1.25, "shift_y": 0, "shift_z": -2}`, nn.getValue(coord{6*0.5 + 1.25, -3 * 0.25, 10*0.5 - 2})},
+		{"old_blended_noise", `{"type": "minecraft:old_blended_noise", "xz_scale": 0.25, "y_scale": 0.125,
+			"xz_factor": 80, "y_factor": 160, "smear_scale_multiplier": 8}`, seededBlendedNoise(seed, 0.25, 0.125, 80, 160, 8).compute(c)},
+		{"end_islands", `{"type": "minecraft:end_islands"}`, newEndIslands(seed).compute(c)},
+	} {
+		l := newDfLoader(testFunctions(map[string]string{tc.name: tc.fn, "number": `2.5`}), seed)
+		f, err := l.load("syph:" + tc.name)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if got := f.compute(c); got != tc.want {
+			t.Errorf("%s: %g, expected %g", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name, fn, err string
+	}{
+		{"null", `null`, "got null"},
+		{"null_argument", `{"type": "minecraft:abs", "argument": null}`, "got null"},
+		{"null_number", `{"type": "minecraft:clamp", "input": 1, "min": null, "max": 2}`, "field min must be a number"},
+		{"null_noise", `{"type": "minecraft:shifted_noise", "noise": null, "xz_scale": 1, "y_scale": 1,
+			"shift_x": 0, "shift_y": 0, "shift_z": 0}`, "field noise must be a string"},
+		{"array", `[1, 2]`, "expected a number, string or object"},
+		{"invalid", `{"type": `, "unexpected end of JSON input"},
+		{"no_type", `{"argument": 1}`, "missing type"},
+		{"unknown_type", `{"type": "minecraft:weird_scaled_sampler"}`, "unsupported type minecraft:weird_scaled_sampler"},
+		{"missing_field", `{"type": "minecraft:add", "argument1": 1}`, "add: missing field argument2"},
+		{"string_number", `{"type": "minecraft:constant", "argument": "1"}`, "field argument must be a number"},
+		{"missing_reference", `"syph:nothing"`, "syph/worldgen/density_function/nothing.json"},
+		{"self", `{"type": "minecraft:add", "argument1": 1, "argument2": "syph:self"}`, "syph:self references itself"},
+		{"missing_noise", `{"type": "minecraft:shifted_noise", "noise": "syph:nothing", "xz_scale": 1, "y_scale": 1,
+			"shift_x": 0, "shift_y": 0, "shift_z": 0}`, "syph/worldgen/noise/nothing.json"},
+		{"unqualified_noise", `{"type": "minecraft:shifted_noise", "noise": "n", "xz_scale": 1, "y_scale": 1,
+			"shift_x": 0, "shift_y": 0, "shift_z": 0}`, "minecraft/worldgen/noise/n.json"},
+		{"octaves", `{"type": "minecraft:shifted_noise", "noise": "syph:octaves", "xz_scale": 1, "y_scale": 1,
+			"shift_x": 0, "shift_y": 0, "shift_z": 0}`, "only a single octave"},
+		{"spline_coordinate", `{"type": "minecraft:spline", "spline": {"points": [{"location": 0, "value": 0, "derivative": 0}]}}`,
+			"missing field coordinate"},
+		{"spline_points", `{"type": "minecraft:spline", "spline": {"coordinate": 0, "points": []}}`, "no points"},
+		{"spline_order", `{"type": "minecraft:spline", "spline": {"coordinate": 0,
+			"points": [{"location": 1, "value": 0, "derivative": 0}, {"location": 1, "value": 0, "derivative": 0}]}}`, "ascending"},
+		{"spline_null", `{"type": "minecraft:spline", "spline": {"coordinate": 0,
+			"points": [{"location": 0, "value": null, "derivative": 0}]}}`, "got null"},
+	} {
+		l := newDfLoader(testFunctions(map[string]string{tc.name: tc.fn}), 1)
+		_, err := l.load("syph:" + tc.name)
+		if err == nil {
+			t.Errorf("%s: no error", tc.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: error %q does not mention %q", tc.name, err, tc.err)
+		}
+		if !strings.Contains(err.Error(), "syph:"+tc.name) && !strings.HasPrefix(tc.name, "missing_") {
+			t.Errorf("%s: error %q does not name the function", tc.name, err)
+		}
+	}
+}
+
+func TestLoaderCaches(t *testing.T) {
+	l := newDfLoader(testFunctions(map[string]string{
+		"a": `{"type": "minecraft:add", "argument1": "syph:b", "argument2": "syph:b"}`,
+		"b": `{"type": "minecraft:shifted_noise", "noise": "syph:n", "xz_scale": 1, "y_scale": 1, "shift_x": 0, "shift_y": 0, "shift_z": 0}`,
+	}), 1)
+	if _, err := l.load("syph:a"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := l.load("syph:b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b != l.fns["syph:b"] || len(l.fns) != 2 || len(l.noises) != 1 {
+		t.Errorf("loaded %d functions and %d noises, expected each once", len(l.fns), len(l.noises))
+	}
+	if b.(dfShiftedNoise).nn != sharedNoises.get(1, "syph:n") {
+		t.Error("the noise is not the shared one for the seed")
+	}
+}
+
+// dfFunc is a density function given by a Go function, for testing nodes
+// that depend on the position.
+type dfFunc func(c intCoord) float64
+
+func (f dfFunc) compute(c intCoord) float64 {
+	return f(c)
+}
+
+func TestNodes(t *testing.T) {
+	nan := dfConstant(math.NaN())
+	y := dfFunc(func(c intCoord) float64 { return float64(c.y) })
+	for _, tc := range []struct {
+		name string
+		f    densityFunction
+		at   intCoord
+		want float64
+	}{
+		{"abs", dfAbs{dfConstant(-2)}, intCoord{}, 2},
+		{"abs positive", dfAbs{dfConstant(2)}, intCoord{}, 2},
+		{"square", dfSquare{dfConstant(-3)}, intCoord{}, 9},
+		{"min", dfMin{dfConstant(-1), dfConstant(4)}, intCoord{}, -1},
+		{"max", dfMax{dfConstant(-1), dfConstant(4)}, intCoord{}, 4},
+		{"mul skips", dfMul{dfConstant(0), nan}, intCoord{}, 0},
+
+		{"clamp below", dfClamp{dfConstant(-1.5), -1, 2}, intCoord{}, -1},
+		{"clamp at min", dfClamp{dfConstant(-1), -1, 2}, intCoord{}, -1},
+		{"clamp inside", dfClamp{dfConstant(0.5), -1, 2}, intCoord{}, 0.5},
+		{"clamp at max", dfClamp{dfConstant(2), -1, 2}, intCoord{}, 2},
+		{"clamp above", dfClamp{dfConstant(3), -1, 2}, intCoord{}, 2},
+
+		{"gradient below", dfYClampedGradient{-64, 320, 1, -1}, intCoord{0, -65, 0}, 1},
+		{"gradient at from", dfYClampedGradient{-64, 320, 1, -1}, intCoord{0, -64, 0}, 1},
+		{"gradient middle", dfYClampedGradient{-64, 320, 1, -1}, intCoord{0, 128, 0}, 0},
+		{"gradient quarter", dfYClampedGradient{-64, 320, 1, -1}, intCoord{0, 32, 0}, 0.5},
+		{"gradient at to", dfYClampedGradient{-64, 320, 1, -1}, intCoord{0, 320, 0}, -1},
+		{"gradient above", dfYClampedGradient{-64, 320, 1, -1}, intCoord{0, 321, 0}, -1},
+
+		{"range at min", dfRangeChoice{y, 0, 8, dfConstant(1), dfConstant(2)}, intCoord{0, 0, 0}, 1},
+		{"range below min", dfRangeChoice{y, 0, 8, dfConstant(1), dfConstant(2)}, intCoord{0, -1, 0}, 2},
+		{"range below max", dfRangeChoice{y, 0, 8, dfConstant(1), dfConstant(2)}, intCoord{0, 7, 0}, 1},
+		{"range at max", dfRangeChoice{y, 0, 8, dfConstant(1), dfConstant(2)}, intCoord{0, 8, 0}, 2},
+		{"range NaN", dfRangeChoice{nan, 0, 8, dfConstant(1), dfConstant(2)}, intCoord{}, 2},
+	} {
+		if got := tc.f.compute(tc.at); got != tc.want {
+			t.Errorf("%s: %g, expected %g", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSpline(t *testing.T) {
+	// points (0, 1) rising to (2, 5) with derivatives 0 and 4, then (4, 5)
+	// with derivative -1
+	s := splineMultipoint{
+		locations:   []float32{0, 2, 4},
+		values:      []spline{splineConstant(1), splineConstant(5), splineConstant(5)},
+		derivatives: []float32{0, 4, -1},
+	}
+	for _, tc := range []struct {
+		at   float64
+		want float32
+	}{
+		// flat extrapolation, as the first derivative is 0
+		{-10, 1},
+		{0, 1},
+		// k = 1/2, p = 0*2 - 4, q = -4*2 + 4: 3 + 1/4 * -4
+		{1, 2},
+		{2, 5},
+		// k = 1/2, p = 4*2 - 0, q = 1*2 + 0: 5 + 1/4 * 5
+		{3, 6.25},
+		// k = 1/4, p = 8, q = 2: 5 + 3/16 * 6.5
+		{2.5, 5 + 3.0/16*6.5},
+		{4, 5},
+		// linear extrapolation with the last derivative
+		{6, 3},
+	} {
+		s.coordinate = dfConstant(tc.at)
+		if got := s.apply(intCoord{}); got != tc.want {
+			t.Errorf("spline at %g: %g, expected %g", tc.at, got, tc.want)
+		}
+	}
+}
+
+func TestInterpolated(t *testing.T) {
+	// quadratic along x and z, so that interpolation differs from the
+	// argument between corners
+	arg := dfFunc(func(c intCoord) float64 { return float64(c.x*c.x + c.y + c.z*c.z) })
+	f := dfInterpolated{arg, 4, 8}
+	for _, tc := range []struct {
+		at   intCoord
+		want float64
+	}{
+		// corners take the value of the argument
+		{intCoord{0, 0, 0}, 0},
+		{intCoord{4, 8, -4}, 40},
+		{intCoord{-4, -8, 8}, 72},
+		// the middle of the cell from 0,0,-4 to 4,8,0: x² and z² are 8 there
+		{intCoord{2, 4, -2}, 20},
+		// 3/4 of the way from x -4 to 0 and 1/4 from z 4 to 8:
+		// lerp(3/4, 16, 0) + -1 + lerp(1/4, 16, 64)
+		{intCoord{-1, -1, 5}, 4 - 1 + 28},
+	} {
+		if got := f.compute(tc.at); got != tc.want {
+			t.Errorf("interpolated at %v: %g, expected %g", tc.at, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"math"
+)
+
+// Derived functions are built from syph:x and syph:z by reference, so they
+// follow whichever parameters were written for those.
+
+const (
+	// the game limits constants in density functions to this magnitude
+	maxConstant = 1e6
+
+	// quadrantScale brings coordinates into the range allowed for the bounds of
+	// range_choice, it is a power of two so the sign test stays exact
+	quadrantScale = 1.0 / 64
+
+	// sqrtRatio is the ratio between consecutive spline points of dist, which
+	// keeps the relative error of the fit below 3e-7
+	sqrtRatio = 1.1
+	sqrtFirst = 1.0 / 1024
+	sqrtLast  = 8e15 // above the largest squared distance inside the world
+)
+
+// derivedFunctions returns the JSON of the derived functions by name.
+// x_rel and z_rel are relative to (cx, cz), dist_sq and dist are the squared
+// and plain distance from it and quadrant is 0 where x_rel and z_rel are both
+// non-negative, counting up counter-clockwise in the x-z plane.
+func derivedFunctions(ns string, cx, cz float64) map[string]any {
+	ref := func(name string) string {
+		return ns + ":" + name
+	}
+	sign := func(name string, neg, pos any) any {
+		return object(
+			"type", "minecraft:range_choice",
+			"input", object("type", "minecraft:mul", "argument1", quadrantScale, "argument2", ref(name)),
+			"min_inclusive", 0.0,
+			"max_exclusive", maxConstant,
+			"when_in_range", pos,
+			"when_out_of_range", neg,
+		)
+	}
+	square := func(name string) any {
+		return object("type", "minecraft:square", "argument", ref(name))
+	}
+
+	return map[string]any{
+		"x_rel":   object("type", "minecraft:add", "argument1", ref("x"), "argument2", -cx),
+		"z_rel":   object("type", "minecraft:add", "argument1", ref("z"), "argument2", -cz),
+		"dist_sq": object("type", "minecraft:add", "argument1", square("x_rel"), "argument2", square("z_rel")),
+		"dist":    object("type", "minecraft:spline", "spline", sqrtSpline(ref("dist_sq"))),
+		"quadrant": sign("z_rel",
+			sign("x_rel", 2.0, 3.0),
+			sign("x_rel", 1.0, 0.0)),
+	}
+}
+
+// sqrtSpline fits the square root of coordinate with points spaced
+// geometrically, using the exact derivative at each point.
+func sqrtSpline(coordinate string) jsonObject {
+	points := []any{object("location", 0.0, "value", 0.0, "derivative", 0.0)}
+	for l := sqrtFirst; ; l *= sqrtRatio {
+		// fit at the location the game will read from the file
+		loc := float64(float32(l))
+		points = append(points, object(
+			"location", loc,
+			"value", math.Sqrt(loc),
+			"derivative", 0.5/math.Sqrt(loc),
+		))
+		if loc > sqrtLast {
+			break
+		}
+	}
+	return object("coordinate", coordinate, "points", points)
+}
+
+// validationSamples are the block coordinates the written functions are
+// checked at, from the origin out to near the world border.
+var validationSamples = []int64{-20000000, -1000000, -4096, -1, 0, 1, 4096, 1000000, 20000000}
+
+// writtenCheck is the value a written function should have at a position.
+type writtenCheck struct {
+	name      string // without the namespace
+	at        intCoord
+	want, tol float64
+}
+
+// checkWritten evaluates the written functions loaded by l against checks,
+// returning the number that passed before the first failure.
+func checkWritten(l *dfLoader, ns string, checks []writtenCheck) (int, error) {
+	for i, ch := range checks {
+		f, err := l.load(ns + ":" + ch.name)
+		if err != nil {
+			return i, err
+		}
+		v := f.compute(ch.at)
+		if !(math.Abs(v-ch.want) <= ch.tol) {
+			return i, fmt.Errorf("%s:%s at %d,%d,%d is %g, expected %g", ns, ch.name, ch.at.x, ch.at.y, ch.at.z, v, ch.want)
+		}
+	}
+	return len(checks), nil
+}
+
+// validateDerived evaluates the written derived functions at sample positions
+// and compares them to the values computed from syph:x and syph:z.
+func validateDerived(fsys fs.FS, seed int64, ns string, cx, cz float64) (int, error) {
+	l := newDfLoader(fsys, seed)
+	x, err := l.load(ns + ":x")
+	if err != nil {
+		return 0, err
+	}
+	z, err := l.load(ns + ":z")
+	if err != nil {
+		return 0, err
+	}
+
+	var checks []writtenCheck
+	for _, sx := range validationSamples {
+		for _, sz := range validationSamples {
+			c := intCoord{sx, 0, sz}
+			xr := x.compute(c) - cx
+			zr := z.compute(c) - cz
+			dsq := xr*xr + zr*zr
+			q := 0.0
+			switch {
+			case xr < 0 && zr >= 0:
+				q = 1
+			case xr < 0 && zr < 0:
+				q = 2
+			case xr >= 0 && zr < 0:
+				q = 3
+			}
+
+			checks = append(checks,
+				writtenCheck{"x_rel", c, xr, 0},
+				writtenCheck{"z_rel", c, zr, 0},
+				writtenCheck{"dist_sq", c, dsq, dsq * 1e-12},
+			)
+			// the functions are only defined for positions inside the world,
+			// which badly fitted coordinates may not be
+			if dsq <= sqrtLast {
+				// dominated by the single precision the game evaluates splines in
+				checks = append(checks, writtenCheck{"dist", c, math.Sqrt(dsq), math.Sqrt(dsq)*1e-6 + 0.05})
+			}
+			if math.Abs(xr) < maxConstant/quadrantScale && math.Abs(zr) < maxConstant/quadrantScale {
+				checks = append(checks, writtenCheck{"quadrant", c, q, 0})
+			}
+		}
+	}
+	return checkWritten(l, ns, checks)
+}
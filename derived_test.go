@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+	"testing/fstest"
+)
+
+// derivedStep is the value of syph:x and syph:z per block in derivedAt, a
+// power of two so that positions give exact fractions.
+const derivedStep = 1.0 / 1024
+
+// derivedAt returns the derived functions relative to (cx, cz), written as
+// JSON and loaded back, with syph:x and syph:z the block coordinates times
+// derivedStep.
+func derivedAt(t *testing.T, cx, cz float64) map[string]densityFunction {
+	t.Helper()
+	files := testFunctions(nil)
+	for name, fn := range derivedFunctions(namespace, cx, cz) {
+		b, err := json.Marshal(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[namespace+"/worldgen/density_function/"+name+".json"] = &fstest.MapFile{Data: b}
+	}
+	l := newDfLoader(files, 1)
+	l.fns[namespace+":x"] = dfFunc(func(c intCoord) float64 { return float64(c.x) * derivedStep })
+	l.fns[namespace+":z"] = dfFunc(func(c intCoord) float64 { return float64(c.z) * derivedStep })
+	fns := make(map[string]densityFunction)
+	for _, name := range []string{"x_rel", "z_rel", "dist_sq", "dist", "quadrant"} {
+		f, err := l.load(namespace + ":" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fns[name] = f
+	}
+	return fns
+}
+
+func TestDerivedFunctions(t *testing.T) {
+	fns := derivedAt(t, 30, -10)
+	for _, tc := range []struct {
+		name string
+		at   intCoord
+		want float64
+	}{
+		{"x_rel", intCoord{100 * 1024, 0, 0}, 70},
+		{"x_rel", intCoord{-512, 0, 0}, -30.5},
+		{"z_rel", intCoord{0, 0, -5 * 1024}, 5},
+		{"z_rel", intCoord{0, 0, -11 * 1024}, -1},
+		// 3² + 4²
+		{"dist_sq", intCoord{33 * 1024, 0, -14 * 1024}, 25},
+		{"dist_sq", intCoord{30 * 1024, 0, -10 * 1024}, 0},
+		{"dist_sq", intCoord{30*1024 + 512, 0, -10 * 1024}, 0.25},
+		{"dist", intCoord{30 * 1024, 0, -10 * 1024}, 0},
+	} {
+		if got := fns[tc.name].compute(tc.at); got != tc.want {
+			t.Errorf("%s at %v: %g, expected %g", tc.name, tc.at, got, tc.want)
+		}
+	}
+}
+
+func TestDistError(t *testing.T) {
+	fns := derivedAt(t, 0, 0)
+	// the spline points are 10% apart, which bounds the relative error of the
+	// cubic fit to 2.9e-7, to which single precision adds up to 6e-8 for the
+	// result and 3e-8 for the rounded squared distance. The fixed first point
+	// at 0 with derivative 0 leaves an error of up to 0.0115 below d = 1/32.
+	const rel, abs = 4e-7, 0.012
+	for x := int64(1); float64(x)*derivedStep < math.Sqrt(sqrtLast); x += x/97 + 1 {
+		for _, z := range []int64{0, x / 3, x} {
+			c := intCoord{x, 0, z}
+			d := math.Hypot(float64(x), float64(z)) * derivedStep
+			if d*d > sqrtLast {
+				continue
+			}
+			if got := fns["dist"].compute(c); !(math.Abs(got-d) <= d*rel+abs) {
+				t.Fatalf("dist at %v: %g, expected %g", c, got, d)
+			}
+		}
+	}
+}
+
+func TestQuadrant(t *testing.T) {
+	fns := derivedAt(t, 0, 0)
+	// the largest coordinate before quadrantScale takes it out of range
+	const far = maxConstant/quadrantScale/derivedStep - 1
+	for _, tc := range []struct {
+		x, z int64
+		want float64
+	}{
+		// zero counts as positive on both axes
+		{0, 0, 0},
+		{1, 1, 0},
+		{-1, 0, 1},
+		{-1, 1, 1},
+		{-1, -1, 2},
+		{0, -1, 3},
+		{1, -1, 3},
+		{far, far, 0},
+		{-far, far, 1},
+		{-far, -far, 2},
+		{far, -far, 3},
+	} {
+		c := intCoord{tc.x, 0, tc.z}
+		if got := fns["quadrant"].compute(c); got != tc.want {
+			t.Errorf("quadrant at %d,%d: %g, expected %g", tc.x, tc.z, got, tc.want)
+		}
+	}
+}
+
+func TestCheckWritten(t *testing.T) {
+	l := newDfLoader(testFunctions(map[string]string{"two": `2`}), 1)
+	checks := []writtenCheck{
+		{"two", intCoord{}, 2, 0},
+		{"two", intCoord{5, 0, 5}, 2.5, 0.5},
+		{"two", intCoord{1, 2, 3}, 3, 0.5},
+		{"nothing", intCoord{}, 0, 0},
+	}
+	n, err := checkWritten(l, "syph", checks)
+	if n != 2 || err == nil || err.Error() != "syph:two at 1,2,3 is 2, expected 3" {
+		t.Errorf("passed %d checks, then %v", n, err)
+	}
+	if n, err := checkWritten(l, "syph", checks[3:]); n != 0 || err == nil {
+		t.Errorf("passed %d checks of a missing function, %v", n, err)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+type rejectReason string
+
+const (
+	rejectNone           rejectReason = ""
+	rejectNaNSlope       rejectReason = "nan_slope"       // the fitted parameters are NaN or Inf
+	rejectZeroDerivative rejectReason = "zero_derivative" // the noise is flat at the fit point
+	rejectLeftDomain     rejectReason = "left_domain"     // the optimiser ended outside of the cell
+	rejectErrorThreshold rejectReason = "error_threshold" // the estimated error is above genOptions.maxError
+)
+
+// rejection records a candidate discarded during a search, along with the
+// parameters that were fitted for it, which may be incomplete.
+type rejection struct {
+	reason rejectReason
+	loc    noiseLocInfo
+	params dfParams
+}
+
+// jsonFloat encodes NaN and Inf as strings, which encoding/json refuses to
+// encode as numbers. These are exactly the values diagnostics care about.
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+	if isNumber(v) {
+		return strconv.AppendFloat(nil, v, 'g', -1, 64), nil
+	}
+	return []byte(`"` + strconv.FormatFloat(v, 'g', -1, 64) + `"`), nil
+}
+
+func jsonCoord(c coord) [3]jsonFloat {
+	return [3]jsonFloat{jsonFloat(c.x), jsonFloat(c.y), jsonFloat(c.z)}
+}
+
+func jsonBounds(b coordBounds) map[string][3]jsonFloat {
+	return map[string][3]jsonFloat{"lo": jsonCoord(b.lo), "hi": jsonCoord(b.hi)}
+}
+
+func (r rejection) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Reason rejectReason            `json:"reason"`
+		Seed   int64                   `json:"seed"`
+		Noise  string                  `json:"noise"`
+		Axis   string                  `json:"axis"`
+		Y      jsonFloat               `json:"y"`
+		B1     map[string][3]jsonFloat `json:"bounds1"`
+		B2     map[string][3]jsonFloat `json:"bounds2"`
+		Shift  [3]jsonFloat            `json:"shift"`
+		Slope  jsonFloat               `json:"slope"`
+		Offset jsonFloat               `json:"offset"`
+		Error  jsonFloat               `json:"error"`
+	}{
+		r.reason, r.loc.dimSeed, r.loc.rl, r.loc.axis.String(), jsonFloat(r.loc.y),
+		jsonBounds(r.loc.b1), jsonBounds(r.loc.b2),
+		jsonCoord(coord{r.params.x, r.params.y, r.params.z}),
+		jsonFloat(r.params.m), jsonFloat(r.params.b), jsonFloat(r.params.err),
+	})
+}
+
+// rejectLog writes rejections as JSON lines and counts them by reason.
+// It is safe for concurrent use.
+type rejectLog struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	err    error
+	counts map[rejectReason]int
+}
+
+func newRejectLog(w io.Writer) *rejectLog {
+	return &rejectLog{enc: json.NewEncoder(w), counts: make(map[rejectReason]int)}
+}
+
+func (l *rejectLog) record(r rejection) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.counts[r.reason]++
+	// keep counting after a write error so the summary stays meaningful
+	if l.err == nil {
+		l.err = l.enc.Encode(r)
+	}
+}
+
+// writeError returns the first error encountered writing rejections.
+func (l *rejectLog) writeError() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// summary returns the number of rejections per reason, sorted by reason.
+func (l *rejectLog) summary() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	reasons := make([]string, 0, len(l.counts))
+	for r := range l.counts {
+		reasons = append(reasons, string(r))
+	}
+	sort.Strings(reasons)
+	s := ""
+	for i, r := range reasons {
+		if i > 0 {
+			s += ", "
+		}
+		s += r + ": " + strconv.Itoa(l.counts[rejectReason(r)])
+	}
+	if s == "" {
+		s = "none"
+	}
+	return s
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONFloat(t *testing.T) {
+	for _, c := range []struct {
+		v    float64
+		want string
+	}{
+		{0, `0`},
+		{-1.5, `-1.5`},
+		{1e-9, `1e-09`},
+		{math.NaN(), `"NaN"`},
+		{math.Inf(1), `"+Inf"`},
+		{math.Inf(-1), `"-Inf"`},
+	} {
+		b, err := json.Marshal(jsonFloat(c.v))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != c.want {
+			t.Errorf("%g encoded as %s, expected %s", c.v, b, c.want)
+		}
+	}
+}
+
+// rejectedLine fits the candidate with index i of a noise of seed 42 and
+// returns the line logged for it, which must be the only one.
+func rejectedLine(t *testing.T, rl string, i int, opts genOptions) map[string]any {
+	t.Helper()
+	d := noiseInfo{42, rl}
+	nn := seededNoise(d.dimSeed, d.rl)
+	locs := alignedCells(d, nn)
+	if i >= len(locs) {
+		t.Fatalf("%s has %d candidates", rl, len(locs))
+	}
+	var b bytes.Buffer
+	l := newRejectLog(&b)
+	opts.reject = l.record
+	if _, ok := fitCandidate(nn, locs[i], opts); ok {
+		t.Fatalf("%s candidate %d accepted", rl, i)
+	}
+	if err := l.writeError(); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("%d lines logged", len(lines))
+	}
+	var m map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &m); err != nil {
+		t.Fatalf("%s: %v", lines[0], err)
+	}
+	if m["seed"] != 42.0 || m["noise"] != rl || m["axis"] != locs[i].axis.String() {
+		t.Errorf("line does not describe %s candidate %d: %s", rl, i, lines[0])
+	}
+	return m
+}
+
+func TestRejectReasons(t *testing.T) {
+	// candidates of seed 42 the fitter discards for each reason
+	for _, c := range []struct {
+		rl     string
+		i      int
+		opts   genOptions
+		reason rejectReason
+	}{
+		{"syph:1", 0, genOptions{}, rejectLeftDomain},
+		{"syph:12", 3, genOptions{}, rejectZeroDerivative},
+		{"syph:0", 0, genOptions{maxError: 1e-300}, rejectErrorThreshold},
+	} {
+		m := rejectedLine(t, c.rl, c.i, c.opts)
+		if m["reason"] != string(c.reason) {
+			t.Errorf("%s candidate %d rejected as %v, expected %s", c.rl, c.i, m["reason"], c.reason)
+		}
+		// the error is only estimated for fits that are otherwise accepted
+		_, isNumber := m["error"].(float64)
+		if isNumber != (c.reason == rejectErrorThreshold) {
+			t.Errorf("%s: error logged as %v", c.reason, m["error"])
+		}
+	}
+}
+
+func TestRejectLog(t *testing.T) {
+	var b bytes.Buffer
+	l := newRejectLog(&b)
+	if s := l.summary(); s != "none" {
+		t.Errorf("empty summary %q", s)
+	}
+	// a NaN slope is not reached by the fitter, as a NaN makes the optimiser
+	// leave the domain first, but is logged all the same
+	loc := noiseLocInfo{dimSeed: 7, rl: "syph:a", axis: axisZ, y: 0.5}
+	l.record(rejection{rejectNaNSlope, loc, dfParams{m: math.NaN(), b: math.Inf(1), err: math.NaN()}})
+	l.record(rejection{rejectLeftDomain, loc, dfParams{}})
+	l.record(rejection{rejectNaNSlope, loc, dfParams{}})
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("%d lines logged, expected 3", len(lines))
+	}
+	want := `{"reason":"nan_slope","seed":7,"noise":"syph:a","axis":"z","y":0.5,` +
+		`"bounds1":{"hi":[0,0,0],"lo":[0,0,0]},"bounds2":{"hi":[0,0,0],"lo":[0,0,0]},` +
+		`"shift":[0,0,0],"slope":"NaN","offset":"+Inf","error":"NaN"}`
+	if lines[0] != want {
+		t.Errorf("logged %s\nexpected %s", lines[0], want)
+	}
+	if s := l.summary(); s != "left_domain: 1, nan_slope: 2" {
+		t.Errorf("summary %q", s)
+	}
+}
+
+func TestRejectsFileError(t *testing.T) {
+	f := searchFlags{rejects: filepath.Join(t.TempDir(), "missing", "rejects.jsonl"), workers: 1}
+	if _, err := runSearch(&f, 42, firstOfEachAxis); err == nil {
+		t.Error("no error creating the rejects file in a missing directory")
+	}
+}
+
+func TestRejectOrder(t *testing.T) {
+	// rejections are reported in the order of the noises whatever the number
+	// of workers
+	type rejected struct {
+		reason rejectReason
+		loc    noiseLocInfo
+	}
+	search := func(workers int) []rejected {
+		var rs []rejected
+		opts := genOptions{workers: workers, noises: 4, reject: func(r rejection) { rs = append(rs, rejected{r.reason, r.loc}) }}
+		genFromDimSeed(context.Background(), 42, opts, func(a int, first bool, d dfParams) (int, bool) { return a, true })
+		return rs
+	}
+	want := search(1)
+	if len(want) == 0 {
+		t.Fatal("no rejections in the first noises")
+	}
+	if got := search(4); !reflect.DeepEqual(got, want) {
+		t.Errorf("four workers rejected %d candidates differently from one, which rejected %d", len(got), len(want))
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import "math"
+
+// endIslands is the game's end_islands density function, which the game
+// seeds with the world seed directly.
+type endIslands struct {
+	noise *simplex
+}
+
+func newEndIslands(seed int64) endIslands {
+	r := newLegacyRandom(seed)
+	r.consumeCount(17292)
+	return endIslands{newSimplex(r)}
+}
+
+func (f endIslands) compute(c intCoord) float64 {
+	// java's integer division, towards zero
+	return (float64(f.height(int32(c.x/8), int32(c.z/8))) - 8) / 128
+}
+
+// height matches getHeightValue, which works in single precision and in 32
+// bit integers, overflowing for positions far from the origin like the game
+// does. Products are converted explicitly so they are never fused.
+func (f endIslands) height(i, j int32) float32 {
+	k, l := i/2, j/2
+	m, n := i%2, j%2
+
+	h := 100 - float32(float32(math.Sqrt(float64(float32(i*i+j*j))))*8)
+	h = clampFloat32(h, -100, 80)
+	for o := int32(-12); o <= 12; o++ {
+		for p := int32(-12); p <= 12; p++ {
+			q, r := int64(k+o), int64(l+p)
+			if q*q+r*r <= 4096 || !(f.noise.value2(float64(q), float64(r)) < float64(float32(-0.9))) {
+				continue
+			}
+			g := float32(math.Mod(float64(float32(abs32(float32(q))*3439)+float32(abs32(float32(r))*147)), 13)) + 9
+			x, z := float32(m-o*2), float32(n-p*2)
+			t := 100 - float32(float32(math.Sqrt(float64(float32(x*x)+float32(z*z))))*g)
+			t = clampFloat32(t, -100, 80)
+			if t > h {
+				h = t
+			}
+		}
+	}
+	return h
+}
+
+func abs32(x float32) float32 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func clampFloat32(x, lo, hi float32) float32 {
+	if x < lo {
+		return lo
+	}
+	if x > hi {
+		return hi
+	}
+	return x
+}
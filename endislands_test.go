@@ -0,0 +1,80 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// values of java.util.Random, which LegacyRandomSource matches
+func TestLegacyRandom(t *testing.T) {
+	if v := newLegacyRandom(0).int32(); v != -1155484576 {
+		t.Errorf("first int of seed 0 is %d", v)
+	}
+	if v := newLegacyRandom(42).int32(); v != -1170105035 {
+		t.Errorf("first int of seed 42 is %d", v)
+	}
+	if v := newLegacyRandom(0).float64(); v != 0.730967787376657 {
+		t.Errorf("first double of seed 0 is %v", v)
+	}
+	r := newLegacyRandom(1)
+	for _, bound := range []int32{1, 7, 64, 256, 1000} {
+		for i := 0; i < 1000; i++ {
+			if v := r.boundedInt32(bound); v < 0 || v >= bound {
+				t.Fatalf("value %d out of range for bound %d", v, bound)
+			}
+		}
+	}
+}
+
+func TestSimplex(t *testing.T) {
+	n := newSimplex(newLegacyRandom(0))
+	var lo, hi float64
+	for x := -100.0; x <= 100; x += 0.37 {
+		for y := -100.0; y <= 100; y += 0.41 {
+			v := n.value2(x, y)
+			if !(math.Abs(v) <= 1) {
+				t.Fatalf("value at %v,%v is %v", x, y, v)
+			}
+			lo, hi = math.Min(lo, v), math.Max(hi, v)
+		}
+	}
+	// the game's islands need values below -0.9
+	if lo > -0.9 || hi < 0.9 {
+		t.Errorf("values only range from %v to %v", lo, hi)
+	}
+}
+
+func TestEndIslands(t *testing.T) {
+	f := newEndIslands(0)
+	// the main island is at its full height at the origin, and the gap around
+	// it, inside 1024 blocks, has no other islands
+	for _, c := range []struct {
+		x, z int64
+		want float64
+	}{
+		{0, 0, (80.0 - 8) / 128},
+		{800, 0, (-100.0 - 8) / 128},
+		{0, -800, (-100.0 - 8) / 128},
+	} {
+		if v := f.compute(intCoord{c.x, 0, c.z}); v != c.want {
+			t.Errorf("value at %d,%d is %v, expected %v", c.x, c.z, v, c.want)
+		}
+	}
+
+	// outer islands
+	islands := 0
+	for x := int64(1100); x < 6000; x += 40 {
+		for z := int64(-2000); z < 2000; z += 40 {
+			v := f.compute(intCoord{x, 0, z})
+			if v < (-100.0-8)/128 || v > (80.0-8)/128 {
+				t.Fatalf("value at %d,%d is %v", x, z, v)
+			}
+			if v > (-100.0-8)/128 {
+				islands++
+			}
+		}
+	}
+	if islands == 0 {
+		t.Error("no outer islands found")
+	}
+}
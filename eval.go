@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+)
+
+func evalMain(args []string) {
+	flags := flag.NewFlagSet("eval", flag.ExitOnError)
+	seed := flags.Int64("seed", 0, "dimension `seed` the noises are constructed for (required)")
+	pack := flags.String("pack", ".", "data pack or namespace parent `folder` holding the function")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: dfcoord eval -seed <dimension seed> [flags] <function> <x,y,z>...")
+		fmt.Fprintln(flags.Output(), "Evaluates a density function read from disk at block positions.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() < 2 || !isFlagSet(flags, "seed") {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	f, err := newDfLoader(packRoot(os.DirFS(*pack)), *seed).load(flags.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, s := range flags.Args()[1:] {
+		c, err := parseBlockPos(s)
+		if err != nil {
+			log.Fatalf("invalid position %q: %v", s, err)
+		}
+		fmt.Printf("%d,%d,%d %s\n", c.x, c.y, c.z, strconv.FormatFloat(f.compute(c), 'g', -1, 64))
+	}
+}
+
+// parseBlockPos parses a block position written as x,y,z.
+func parseBlockPos(s string) (intCoord, error) {
+	v, err := parseFloats(s, 3)
+	if err != nil {
+		return intCoord{}, err
+	}
+	for _, f := range v {
+		if f != float64(int64(f)) {
+			return intCoord{}, fmt.Errorf("%v is not a block coordinate", f)
+		}
+	}
+	return intCoord{int64(v[0]), int64(v[1]), int64(v[2])}, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Within a bounded region the residual of a linear function, the coordinate
+// minus its value, is a smooth function of that value and is corrected by a
+// spline over it. The game evaluates splines in single precision, which is
+// plenty for the residual, as it is small compared to the coordinate. The
+// spline takes the value of the uncorrected function, written as <axis>_linear,
+// as its coordinate:
+//
+//	x = add(x_linear, spline(coordinate x_linear, residual))
+
+const (
+	// exactTolerance is the largest error in blocks a corrected function may
+	// have inside its region
+	exactTolerance = 0.01
+	// maxCorrectionPoints limits the size of the spline
+	maxCorrectionPoints = 1024
+	// maxExactRegion is the largest region a correction is fitted over.
+	// Single precision values below twice it, which leaves room for the error
+	// of the uncorrected function, are 1/128 of a block apart at most, so the
+	// spline resolves exactTolerance over the region.
+	maxExactRegion = 1 << 16
+)
+
+// checkRegion reports whether region can be corrected, 0 meaning no correction.
+func checkRegion(region float64) error {
+	if !(region >= 0 && region <= maxExactRegion) {
+		return fmt.Errorf("region %g is not in [0, %d]", region, maxExactRegion)
+	}
+	return nil
+}
+
+// correct corrects both functions of t over region, if it is not 0.
+func (t *twoParams) correct(region float64) error {
+	if region == 0 {
+		return nil
+	}
+	for _, p := range []*dfParams{&t.x, &t.z} {
+		c, err := p.withCorrection(region)
+		if err != nil {
+			return err
+		}
+		*p = c
+	}
+	return nil
+}
+
+// correction is a spline added to a linear function, fitted to its residual
+// over the blocks within region of the origin along the axis.
+type correction struct {
+	region float64
+	linear dfParams // the uncorrected function, the coordinate of the spline
+	factor float32  // power of two scaling the values, from unit conversions
+	spline splineMultipoint
+	maxErr float64 // largest error found at the checked positions
+}
+
+// apply returns the correction for a value u of the linear function, computed
+// the way the game does.
+func (c *correction) apply(u float64) float64 {
+	s := c.spline
+	s.coordinate = dfConstant(u)
+	// scaling by a power of two commutes with every step of the evaluation
+	return float64(c.factor * s.apply(intCoord{}))
+}
+
+// points returns the JSON of the spline points.
+func (c *correction) points() []any {
+	var points []any
+	for i, loc := range c.spline.locations {
+		points = append(points, object(
+			"location", loc,
+			"value", c.factor*float32(c.spline.values[i].(splineConstant)),
+			"derivative", c.factor*c.spline.derivatives[i],
+		))
+	}
+	return points
+}
+
+// linearName is the name the uncorrected function of an axis is written as.
+func linearName(a axis) string {
+	return a.String() + "_linear"
+}
+
+// correctedFunction returns the JSON of p with its correction, where linear
+// is the uncorrected function of p, uncached if inline, and coordinate names
+// the uncorrected function of the axis.
+func (p dfParams) correctedFunction(linear any, coordinate string) jsonObject {
+	return object(
+		"type", "minecraft:flat_cache",
+		"argument", object(
+			"type", "minecraft:add",
+			"argument1", linear,
+			"argument2", object(
+				"type", "minecraft:spline",
+				"spline", object("coordinate", coordinate, "points", p.corr.points()),
+			),
+		),
+	)
+}
+
+// withCorrection fits a correction to p over the blocks within region of the
+// origin, doubling the number of points until the error is at most
+// exactTolerance.
+func (p dfParams) withCorrection(region float64) (dfParams, error) {
+	p.corr = nil
+	nn := sharedNoises.get(p.dimSeed, p.rl)
+	f := func(t float64) float64 {
+		if p.axis == axisX {
+			return p.value(nn, t, 0)
+		}
+		return p.value(nn, 0, t)
+	}
+	// inverse returns the position at which f is u, and the slope of f there
+	inverse := func(u float64) (t, slope float64) {
+		t = u
+		for i := 0; i < 8; i++ {
+			slope = (f(t+1) - f(t-1)) / 2
+			t -= (f(t) - u) / slope
+		}
+		return t, slope
+	}
+	knot := func(loc float32) (value, derivative float32) {
+		t, slope := inverse(float64(loc))
+		return float32(t - float64(loc)), float32(1/slope - 1)
+	}
+
+	lo, hi := f(-region), f(region)
+	if !(lo < hi) {
+		return p, fmt.Errorf("%s: the function does not increase over the region", p.axis)
+	}
+	c := &correction{region: region, linear: p, factor: 1}
+	for n := 8; n <= maxCorrectionPoints; n *= 2 {
+		s := splineMultipoint{}
+		add := func(loc float32, v, d float32) {
+			if k := len(s.locations); k > 0 && !(loc > s.locations[k-1]) {
+				return
+			}
+			s.locations = append(s.locations, loc)
+			s.values = append(s.values, splineConstant(v))
+			s.derivatives = append(s.derivatives, d)
+		}
+		// flat beyond twice the region, so that the correction stays bounded
+		// outside of it
+		far := float32(f(-2 * region))
+		v, _ := knot(far)
+		add(far, v, 0)
+		for i := 0; i <= n; i++ {
+			loc := float32(lerp(float64(i)/float64(n), lo, hi))
+			v, d := knot(loc)
+			add(loc, v, d)
+		}
+		far = float32(f(2 * region))
+		v, _ = knot(far)
+		add(far, v, 0)
+		c.spline = s
+
+		c.maxErr = 0
+		// every block of the region is checked
+		for t := -math.Floor(region); t <= region; t++ {
+			u := f(t)
+			e := math.Abs(u + c.apply(u) - t)
+			if !(e <= c.maxErr) {
+				c.maxErr = e
+			}
+		}
+		if c.maxErr <= exactTolerance {
+			p.corr = c
+			p.err = p.estimateError(nn)
+			return p, nil
+		}
+	}
+	return p, fmt.Errorf("%s: no correction within %g blocks over %g blocks of the origin with %d points, error %g",
+		p.axis, exactTolerance, region, maxCorrectionPoints, c.maxErr)
+}
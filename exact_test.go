@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestCorrection(t *testing.T) {
+	const seed, region = 1, 4096
+	p := genFromDimSeed(context.Background(), seed, genOptions{}, firstOfEachAxis)
+	files := fstest.MapFS{}
+	var corrected twoParams
+	for _, d := range []dfParams{p.x, p.z} {
+		c, err := d.withCorrection(region)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.axis == axisX {
+			corrected.x = c
+		} else {
+			corrected.z = c
+		}
+		name := d.axis.String()
+		// inlined, as for functions in other units, the uncorrected function
+		// is not cached a second time
+		inline, err := json.Marshal(c.correctedFunction(c.uncached(), namespace+":"+linearName(d.axis)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := bytes.Count(inline, []byte("minecraft:flat_cache")); n != 1 {
+			t.Errorf("%s: %d flat_cache nodes in the corrected function", d.axis, n)
+		}
+		for fn, v := range map[string]any{
+			linearName(d.axis): c.function(),
+			name:               c.correctedFunction(namespace+":"+linearName(d.axis), namespace+":"+linearName(d.axis)),
+		} {
+			b, err := json.Marshal(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[namespace+"/worldgen/density_function/"+fn+".json"] = &fstest.MapFile{Data: b}
+		}
+		ns, id := split(d.rl)
+		files[ns+"/worldgen/noise/"+id+".json"] = &fstest.MapFile{Data: []byte(noiseFile)}
+	}
+
+	l := newDfLoader(files, seed)
+	for _, c := range []dfParams{corrected.x, corrected.z} {
+		f, err := l.load(namespace + ":" + c.axis.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		nn := seededNoise(seed, c.rl)
+		for v := int64(-region); v <= region; v += 7 {
+			pos := intCoord{0, 0, v}
+			if c.axis == axisX {
+				pos = intCoord{v, 0, 0}
+			}
+			got := f.compute(pos)
+			if want := c.value(nn, float64(pos.x), float64(pos.z)); got != want {
+				t.Fatalf("%s at %d: evaluated %g, corrected function gives %g", c.axis, v, got, want)
+			}
+			if math.Abs(got-float64(v)) > exactTolerance {
+				t.Fatalf("%s at %d is %g", c.axis, v, got)
+			}
+		}
+	}
+
+	var b bytes.Buffer
+	corrected.okx, corrected.okz = true, true
+	if err := writeReport(&b, seed, corrected); err != nil {
+		t.Fatal(err)
+	}
+	_, r, err := readReport(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.x, corrected.x) || !reflect.DeepEqual(r.z, corrected.z) {
+		t.Error("correction fitted differently after reading the report")
+	}
+}
+
+func TestCheckRegion(t *testing.T) {
+	for _, region := range []float64{0, 1, 4096, maxExactRegion} {
+		if err := checkRegion(region); err != nil {
+			t.Errorf("region %g: %v", region, err)
+		}
+	}
+	for _, region := range []float64{-1, maxExactRegion + 1, worldBorder, math.NaN()} {
+		if err := checkRegion(region); err == nil {
+			t.Errorf("region %g accepted", region)
+		}
+	}
+	// single precision resolves the tolerance below twice the largest region
+	s := float32(2 * maxExactRegion)
+	if gap := s - math.Nextafter32(s, 0); !(float64(gap) <= exactTolerance) {
+		t.Errorf("spline coordinates below %g are %g apart", s, gap)
+	}
+}
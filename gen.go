@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"math"
 	"runtime"
 	"strconv"
@@ -30,6 +32,12 @@ type dfParams struct {
 	axis    axis
 	x, y, z float64
 	m, b    float64
+	scale   float64     // xz_scale of the noise, the amplifier is its inverse
+	err     float64     // largest error seen at the probe positions, in blocks
+	cert    float64     // proven bound on the error over the world, 0 if not certified
+	reach   float64     // distance from the origin over which the noise stays in the fitted cells, 0 if unknown
+	extra   []dfTerm    // further noise terms added to the first, see combine.go
+	corr    *correction // spline correcting the function near the origin, see exact.go
 }
 
 type axis int
@@ -39,68 +47,128 @@ const (
 	axisZ
 )
 
-// The function rd acts as a reduce callback over the infinite, generated stream of parameter lists.
-// genFromDimSeed will terminate when rd returns false.
-func genFromDimSeed[T any](dimSeed int64, rd func(a T, first bool, d dfParams) (accum T, cont bool)) T {
-	cpu := runtime.NumCPU()
-
-	nStop := make(chan struct{})
-	nOut := make(chan noiseInfo, cpu)
-
-	workerIn := nOut
-	workerOuts := make([]chan dfParams, cpu)
-	for i := range workerOuts {
-		workerOuts[i] = make(chan dfParams, 2)
+func (a axis) String() string {
+	if a == axisX {
+		return "x"
 	}
+	return "z"
+}
 
-	params := make(chan dfParams, cpu)
+// genOptions controls how candidates are accepted during a search.
+type genOptions struct {
+	// maxError rejects candidates whose estimated error exceeds it, disabled if 0
+	maxError float64
+	// scale is the xz_scale of the fitted functions, defaultInputScale if 0
+	scale float64
+	// shard restricts the search to some of the noises, all if its zero value
+	shard shard
+	// workers is the number of noises searched at once, runtime.NumCPU() if 0
+	workers int
+	// noises is the number of noises searched before giving up, unlimited if 0
+	noises int
+	// reject, if not nil, is called for every discarded candidate, in the
+	// order of the search and from one goroutine at a time.
+	reject func(r rejection)
+}
 
-	channels.MergeS(params, workerOuts)
+// noiseFits holds the candidates of a noise and those discarded, in the order
+// they were fitted in.
+type noiseFits struct {
+	params  []dfParams
+	rejects []rejection
+}
+
+// genFromDimSeed searches the noises of the dimension seed on opts.workers
+// goroutines and passes their candidates to the reduce callback rd in the
+// order of the noises, so that the result does not depend on the number of
+// workers. genFromDimSeed returns when rd returns false, ctx is done or
+// opts.noises have been searched, once the workers have stopped, each after at
+// most the candidate it is fitting. The rejections of every noise whose
+// candidates reached rd are reported, and possibly those of the next.
+func genFromDimSeed[T any](ctx context.Context, dimSeed int64, opts genOptions, rd func(a T, first bool, d dfParams) (accum T, cont bool)) T {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	workers := opts.workers
+	if workers == 0 {
+		workers = runtime.NumCPU()
+	}
 
-	// noiseInfo producer
+	var noises <-chan noiseInfo
+	all := make(chan noiseInfo)
 	go func() {
-		defer close(nOut)
-		for i := int64(0); ; i++ {
+		defer close(all)
+		for i := opts.shard.k; ; i += opts.shard.stride() {
 			select {
-			case _, ok := <-nStop:
-				if !ok {
-					return
-				}
-			case nOut <- noiseInfo{dimSeed, namespace + ":" + strconv.FormatInt(i, 36)}:
+			case <-ctx.Done():
+				return
+			case all <- noiseInfo{dimSeed, namespace + ":" + strconv.FormatInt(i, 36)}:
 			}
 		}
 	}()
+	noises = all
+	if opts.noises > 0 {
+		noises = channels.Take(ctx, noises, opts.noises)
+	}
 
-	for _, c := range workerOuts {
-		in := workerIn
-		out := c
+	// each worker fits a whole noise, so that its results can be put back in
+	// the order of the noises
+	results := channels.ParallelMap(ctx, workers, noises, func(d noiseInfo) noiseFits {
+		var f noiseFits
+		o := opts
+		if opts.reject != nil {
+			o.reject = func(r rejection) { f.rejects = append(f.rejects, r) }
+		}
+		genFromNoiseInfo(ctx, d, o, func(p dfParams) bool {
+			f.params = append(f.params, p)
+			return true
+		})
+		return f
+	})
+
+	// the rejections are sent first, so that none of a noise whose candidates
+	// were reduced is lost when the search stops
+	fits := make(chan noiseFits)
+	outs := []chan<- noiseFits{fits}
+	reported := make(chan struct{})
+	if opts.reject != nil {
+		rejects := make(chan noiseFits, 1)
+		outs = []chan<- noiseFits{rejects, fits}
 		go func() {
-			defer close(out)
-			for p := range in {
-				for _, v := range genFromNoiseInfo(p) {
-					out <- v
+			defer close(reported)
+			for f := range rejects {
+				for _, r := range f.rejects {
+					opts.reject(r)
 				}
 			}
 		}()
+	} else {
+		close(reported)
 	}
+	channels.SplitCtx(ctx, results, outs...)
 
 	var accum T
-	first := true
-	for p := range params {
-		var cont bool
-		accum, cont = rd(accum, first, p)
-		first = false
-		// concurrently draining the input ensures correctness but allows program to terminate earlier
+	first, cont := true, true
+	for f := range fits {
+		for _, p := range f.params {
+			accum, cont = rd(accum, first, p)
+			first = false
+			if !cont {
+				break
+			}
+		}
 		if !cont {
-			close(nStop)
-			go func() {
-				for range params {
-				}
-			}()
 			break
 		}
 	}
 
+	// once the splitter has closed its outputs it no longer reads the
+	// results, which are closed after the workers have returned
+	cancel()
+	for range fits {
+	}
+	for range results {
+	}
+	<-reported
 	return accum
 }
 
@@ -109,63 +177,25 @@ const (
 	searchMax = 128.0
 )
 
-func genFromNoiseInfo(d noiseInfo) (p []dfParams) {
-	xh := newXoroshiro(upgradeSeedTo128Bit(d.dimSeed)).forkFixed().fromHash(d.rl)
-	nn := newNormalNoise(xh)
-	p = make([]dfParams, 0)
-	for x := searchMin; x <= searchMax; x++ {
-		for y := searchMin; y <= searchMax; y++ {
-			for z := searchMin; z <= searchMax; z++ {
-				c := coord{x, y, z}
-				v := nn.getVectorsIntNoWrap(c)
-				vux1, vlx1, vuz1, vlz1 := is12AlignedVectorSetInt(v)
-				vux2, vlx2, vuz2, vlz2 := is12AlignedVectorSetInt(v)
-				if vux1 || vlx1 || vuz1 || vlz1 || vux2 || vlx2 || vuz2 || vlz2 {
-					b1 := nn.boundsNoise1(c)
-					b2 := nn.boundsNoise2(c)
-					l1 := b1.lo.y > b2.lo.y
-					l2 := b1.hi.y > b2.hi.y
-					var axis axis
-					if vux1 || vlx1 || vux2 || vlx2 {
-						axis = axisX
-					} else {
-						axis = axisZ
-					}
-					if l1 && l2 && (vux1 || vlx1 || vuz1 || vlz1) {
-						var yr float64
-						if vux1 || vuz1 {
-							yr = b2.hi.y
-						} else {
-							yr = b1.lo.y
-						}
-						params := genFromNoiseLoc(noiseLocInfo{d.dimSeed, d.rl, axis, b1, b2, yr})
-						// it is arguably a bug if the parameters result in NaN or
-						// Inf but the easiest solution is to ignore them for now
-						valid := validateParams(params)
-						if valid {
-							p = append(p, params)
-						}
-					}
-					if !l1 && !l2 && (vux2 || vlx2 || vuz2 || vlz2) {
-						var yr float64
-						if vux2 || vuz2 {
-							yr = b1.hi.y
-						} else {
-							yr = b2.lo.y
-						}
-						params := genFromNoiseLoc(noiseLocInfo{d.dimSeed, d.rl, axis, b1, b2, yr})
-						// it is arguably a bug if the parameters result in NaN or
-						// Inf but the easiest solution is to ignore them for now
-						valid := validateParams(params)
-						if valid {
-							p = append(p, params)
-						}
-					}
-				}
-			}
-		}
+// genFromNoiseInfo fits the candidates of a noise, calling yield with each as
+// it is found, and stops once yield returns false or ctx is done.
+func genFromNoiseInfo(ctx context.Context, d noiseInfo, opts genOptions, yield func(p dfParams) bool) {
+	if ctx.Err() != nil {
+		return
 	}
-	return p
+	// the search visits every noise of the seed once, caching them in
+	// sharedNoises would only evict the ones the evaluator uses
+	nn := seededNoise(d.dimSeed, d.rl)
+	eachAlignedCell(d, nn, func(loc noiseLocInfo) bool {
+		if ctx.Err() != nil {
+			return false
+		}
+		params, ok := fitCandidate(nn, loc, opts)
+		if !ok {
+			return true
+		}
+		return yield(params)
+	})
 }
 
 func isAlignedVectorSet(s1 [8]byte, s2 [8]byte) (x bool, z bool) {
@@ -247,15 +277,241 @@ func is12AlignedVectorSetInt(s uint64) (ux bool, lx bool, uz bool, lz bool) {
 	return xsUpper == 0, xsLower == 0, zsUpper == 0, zsLower == 0
 }
 
+func is12AlignedVectorSetIntMirrored(s uint64) (ux bool, lx bool, uz bool, lz bool) {
+
+	// this is the 12 vector check for the case where y of s2 > y of s1, it mirrors
+	// is12AlignedVectorSetInt by swapping which set is checked in full
+
+	// upper means only the upper vectors of the first set are checked, with the
+	// function evaluated at the top of the first cell, lower means only the lower
+	// vectors of the second set are checked, evaluated at the bottom of the second cell
+
+	// z direction check, all 0s if all checks pass
+	isz := s&0xCCCCCCCCCCCCCCCC ^ 0x8888888888888888
+
+	iszUpper := isz & 0x00FF00FFFFFFFFFF // ignore the lower vectors of the first set
+	iszLower := isz & 0xFFFFFFFFFF00FF00 // ignore the upper vectors of the second set
+
+	// x direction check, all 0s if all checks pass
+	isx := s & 0xCCCCCCCCCCCCCCCC
+
+	isxUpper := isx & 0x00FF00FFFFFFFFFF // ignore the lower vectors of the first set
+	isxLower := isx & 0xFFFFFFFFFF00FF00 // ignore the upper vectors of the second set
+
+	// z pair checks, all 0s if all checks pass
+	pz := (s>>16 ^ s) // check that vectors match and do not mask away garbage
+
+	pzUpper := pz & 0x000000FF0000FFFF // mask away garbage and lower vectors of first set
+	pzLower := pz & 0x0000FFFF0000FF00 // mask away garbage and upper vectors of second set
+
+	// x pair checks, all 0s if all checks pass
+	px := (s>>4 ^ s) & 0x0F0F0F0F0F0F0F0F // XOR the bits that should match and mask the garbage
+
+	pxUpper := px & 0x000F000F0F0F0F0F // mask away garbage and lower vectors of first set
+	pxLower := px & 0x0F0F0F0F0F000F00 // mask away garbage and upper vectors of second set
+
+	// 0 if valid vector set on xy plane
+
+	xsUpper := isxUpper | pzUpper
+	xsLower := isxLower | pzLower
+
+	// 0 if valid vector set on zy plane
+
+	zsUpper := iszUpper | pxUpper
+	zsLower := iszLower | pxLower
+
+	return xsUpper == 0, xsLower == 0, zsUpper == 0, zsLower == 0
+}
+
+// fitCandidate fits parameters at a candidate location, reporting it to
+// opts.reject instead if it has to be discarded.
+func fitCandidate(nn *normalNoise, loc noiseLocInfo, opts genOptions) (dfParams, bool) {
+	scale := opts.scale
+	if scale == 0 {
+		scale = defaultInputScale
+	}
+	params, reason := genFromNoiseLoc(nn, loc, scale)
+	if reason == rejectNone && opts.maxError > 0 && !(params.err <= opts.maxError) {
+		reason = rejectErrorThreshold
+	}
+	if reason == rejectNone {
+		return params, true
+	}
+	if opts.reject != nil {
+		opts.reject(rejection{reason, loc, params})
+	}
+	return params, false
+}
+
 func isNumber(x float64) bool {
 	return !(math.IsInf(x, 0) || math.IsNaN(x))
 }
 
 func validateParams(p dfParams) bool {
+	for _, t := range p.extra {
+		if !(isNumber(t.m) && isNumber(t.x) && isNumber(t.y) && isNumber(t.z)) {
+			return false
+		}
+	}
 	return isNumber(p.m) && isNumber(p.b) && isNumber(p.x) && isNumber(p.y) && isNumber(p.z)
 }
 
-func genFromNoiseLoc(res noiseLocInfo) dfParams {
+// probeDistances are the block distances from the origin along the axis at
+// which the error of a fitted function is estimated.
+var probeDistances = []float64{1e3, 1e4, 1e5, 1e6, 1e7, worldBorder}
+
+const worldBorder = 3e7
+
+// defaultInputScale is the xz_scale of the emitted functions unless chosen
+// otherwise. A smaller scale lowers the error, which grows with the scale
+// times the squared distance, but coarsens the steps the noise input can take.
+// A larger one limits the distance over which the noise stays in the fitted
+// cells.
+const defaultInputScale = 1.0e-9
+
+// checkInputScale reports whether scale can be used as the xz_scale of the
+// emitted functions.
+func checkInputScale(scale float64) error {
+	if !(scale > 0 && scale <= 1) {
+		return fmt.Errorf("xz_scale %g is not in (0, 1]", scale)
+	}
+	return nil
+}
+
+// amplifierFactors splits the inverse of scale into constants within the
+// range the game allows. The last one is rounded to 15 digits if that moves it
+// by at most two units in the last place, so that round scales give round
+// factors rather than 999.9999999999999 while others keep their precision.
+func amplifierFactors(scale float64) []float64 {
+	a := 1 / scale
+	var f []float64
+	for a > maxConstant {
+		f = append(f, maxConstant)
+		a /= maxConstant
+	}
+	r, _ := strconv.ParseFloat(strconv.FormatFloat(a, 'g', 15, 64), 64)
+	if math.Abs(r-a) <= 2*(math.Nextafter(a, math.Inf(1))-a) {
+		a = r
+	}
+	return append(f, a)
+}
+
+// amplifier returns the multiplier of the emitted function, computed like the
+// game does from the nested constants.
+func (p dfParams) amplifier() float64 {
+	f := amplifierFactors(p.scale)
+	a := f[0]
+	for _, v := range f[1:] {
+		a *= v
+	}
+	return a
+}
+
+// value returns the value of the emitted density function at a block position,
+// summing the terms in the order the game does.
+func (p dfParams) value(nn *normalNoise, x, z float64) float64 {
+	v := p.m * nn.getValue(coord{x*p.scale + p.x, p.y, z*p.scale + p.z})
+	for _, t := range p.extra {
+		v += t.m * sharedNoises.get(p.dimSeed, t.rl).getValue(coord{x*p.scale + t.x, t.y, z*p.scale + t.z})
+	}
+	v = p.amplifier() * (p.b + v)
+	if p.corr != nil {
+		v += p.corr.apply(p.corr.linear.value(nn, x, z))
+	}
+	return v
+}
+
+// resolution returns the smallest step in blocks the emitted function can
+// take near the origin, limited by the precision of the noise input.
+func (p dfParams) resolution() float64 {
+	s := p.x
+	if p.axis == axisZ {
+		s = p.z
+	}
+	s = math.Abs(s)
+	return (math.Nextafter(s, math.Inf(1)) - s) / p.scale
+}
+
+// function returns the JSON of the emitted density function, without its
+// correction if it has one.
+func (p dfParams) function() jsonObject {
+	return object("type", "minecraft:flat_cache", "argument", p.uncached())
+}
+
+// uncached returns the JSON of the emitted density function without its
+// flat_cache, for inlining into functions that cache themselves.
+func (p dfParams) uncached() jsonObject {
+	f := amplifierFactors(p.scale)
+	var amp any = f[0]
+	for _, v := range f[1:] {
+		amp = object("type", "minecraft:mul", "argument1", amp, "argument2", v)
+	}
+	term := func(t dfTerm) jsonObject {
+		noise := object(
+			"type", "minecraft:shifted_noise",
+			"noise", t.rl,
+			"xz_scale", p.scale,
+			"y_scale", 0.0,
+			"shift_x", t.x,
+			"shift_y", t.y,
+			"shift_z", t.z,
+		)
+		return object("type", "minecraft:mul", "argument1", t.m, "argument2", noise)
+	}
+	sum := term(p.term())
+	for _, t := range p.extra {
+		sum = object("type", "minecraft:add", "argument1", sum, "argument2", term(t))
+	}
+	return object(
+		"type", "minecraft:mul",
+		"argument1", amp,
+		"argument2", object(
+			"type", "minecraft:add",
+			"argument1", p.b,
+			"argument2", sum,
+		),
+	)
+}
+
+// probes returns the probe distances within the reach of p, and the reach
+// itself, or all of them if the reach is unknown or beyond the world border.
+func (p dfParams) probes() []float64 {
+	if !(p.reach > 0 && p.reach < worldBorder) {
+		return probeDistances
+	}
+	var ds []float64
+	for _, d := range probeDistances {
+		if d < p.reach {
+			ds = append(ds, d)
+		}
+	}
+	return append(ds, p.reach)
+}
+
+// estimateError returns the largest absolute error of p at the probe distances
+// within its reach.
+func (p dfParams) estimateError(nn *normalNoise) float64 {
+	var e float64
+	for _, d := range p.probes() {
+		for _, t := range [2]float64{d, -d} {
+			var v float64
+			if p.axis == axisX {
+				v = p.value(nn, t, 0)
+			} else {
+				v = p.value(nn, 0, t)
+			}
+			// written so that a NaN error is never smaller than e
+			if !(math.Abs(v-t) <= e) {
+				e = math.Abs(v - t)
+			}
+		}
+	}
+	return e
+}
+
+// genFromNoiseLoc fits parameters at a candidate location of nn, which must be
+// the noise the location was found in, for the given xz_scale.
+func genFromNoiseLoc(nn *normalNoise, res noiseLocInfo, scale float64) (dfParams, rejectReason) {
 	// this whole funcion likely needs to be refactored, I wrote it once and haven't touched it since
 	derivative := func(f func(float64) float64, d float64) func(float64) float64 {
 		return func(x float64) float64 {
@@ -263,8 +519,6 @@ func genFromNoiseLoc(res noiseLocInfo) dfParams {
 		}
 	}
 
-	nn := newNormalNoise(newXoroshiro(upgradeSeedTo128Bit(res.dimSeed)).forkFixed().fromHash(res.rl))
-
 	var px, py, pz float64
 	py = res.y
 
@@ -272,10 +526,13 @@ func genFromNoiseLoc(res noiseLocInfo) dfParams {
 		min float64
 		max float64
 	}{}
+	// half the extent of the cells across the axis, around the fit
+	var across float64
 	var noiseGetter func(float64) float64
 	if res.axis == axisX {
-		zMid := (math.Max(res.b1.lo.z, res.b2.lo.z+math.Min(res.b1.hi.z, res.b2.hi.z))) / 2
+		zMid := (math.Max(res.b1.lo.z, res.b2.lo.z) + math.Min(res.b1.hi.z, res.b2.hi.z)) / 2
 		pz = zMid
+		across = math.Min(res.b1.hi.z, res.b2.hi.z) - zMid
 		xMin := math.Max(res.b1.lo.x, res.b2.lo.x)
 		xMax := math.Min(res.b1.hi.x, res.b2.hi.x)
 		noiseGetter = func(x float64) float64 {
@@ -287,6 +544,7 @@ func genFromNoiseLoc(res noiseLocInfo) dfParams {
 	if res.axis == axisZ {
 		xMid := (math.Max(res.b1.lo.x, res.b2.lo.x) + math.Min(res.b1.hi.x, res.b2.hi.x)) / 2
 		px = xMid
+		across = math.Min(res.b1.hi.x, res.b2.hi.x) - xMid
 		zMin := math.Max(res.b1.lo.z, res.b2.lo.z)
 		zMax := math.Min(res.b1.hi.z, res.b2.hi.z)
 		noiseGetter = func(x float64) float64 {
@@ -316,13 +574,19 @@ func genFromNoiseLoc(res noiseLocInfo) dfParams {
 			return l(noiseGetter(x+p)) - (x + p)
 		}
 
+		// the probes stay inside the cells, beyond which the fit is not used
+		r := math.Min(math.Min(x-domain.min, domain.max-x), across)
+		probe := func(d float64) float64 {
+			return math.Min(d*scale, r)
+		}
+
 		points := []float64{
-			e(0.001),  // 1 million blocks
-			e(0.0001), // 100k, etc
-			e(0.00001),
-			e(-0.00001),
-			e(-0.0001),
-			e(-0.001),
+			e(probe(1e6)), // 1 million blocks
+			e(probe(1e5)), // 100k, etc
+			e(probe(1e4)),
+			e(-probe(1e4)),
+			e(-probe(1e5)),
+			e(-probe(1e6)),
 		}
 
 		var t float64 = 0
@@ -366,5 +630,18 @@ func genFromNoiseLoc(res noiseLocInfo) dfParams {
 		pz = math.Max(res.b1.lo.z, res.b2.lo.z) + pt
 	}
 
-	return dfParams{res.dimSeed, res.rl, res.axis, px, py, pz, slope, offset}
+	p := dfParams{res.dimSeed, res.rl, res.axis, px, py, pz, slope, offset, scale, math.NaN(), 0, 0, nil, nil}
+
+	switch {
+	case !(pt >= domain.min && pt <= domain.max):
+		return p, rejectLeftDomain
+	case dNoiseGetter(pt) == 0:
+		return p, rejectZeroDerivative
+	case !validateParams(p):
+		return p, rejectNaNSlope
+	}
+
+	p.reach = math.Min(math.Min(pt-domain.min, domain.max-pt), across) / scale
+	p.err = p.estimateError(nn)
+	return p, rejectNone
 }
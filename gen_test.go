@@ -0,0 +1,526 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"strconv"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// Corners are indexed in the order used by perlin.vectors, xyz 000, 100, 010,
+// 110, 001, 101, 011, 111, so bit 0 is x, bit 1 is y and bit 2 is z.
+var (
+	allCorners   = []int{0, 1, 2, 3, 4, 5, 6, 7}
+	upperCorners = []int{2, 3, 6, 7}
+	lowerCorners = []int{0, 1, 4, 5}
+)
+
+// alignedRef is a slow reference for the packed vector checks. It reports
+// whether the gradients at the given corners all lie in the plane of y and a,
+// with the gradients of corners differing only along the other horizontal
+// axis being equal, which makes the noise independent of that axis.
+func alignedRef(v [8]byte, corners []int, a axis) bool {
+	pair := 4 // corners differing in z
+	plane := 2
+	if a == axisZ {
+		pair = 1
+		plane = 0
+	}
+	var in [8]bool
+	for _, c := range corners {
+		in[c] = true
+	}
+	for _, c := range corners {
+		if gradients[v[c]][plane] != 0 {
+			return false
+		}
+		if in[c^pair] && v[c] != v[c^pair] {
+			return false
+		}
+	}
+	return true
+}
+
+// isAlignedRef is the reference for isAlignedVectorSet.
+func isAlignedRef(v1, v2 [8]byte) (x, z bool) {
+	return alignedRef(v1, allCorners, axisX) && alignedRef(v2, allCorners, axisX),
+		alignedRef(v1, allCorners, axisZ) && alignedRef(v2, allCorners, axisZ)
+}
+
+// is12AlignedRef is the reference for is12AlignedVectorSetInt and, if
+// mirrored, is12AlignedVectorSetIntMirrored.
+func is12AlignedRef(v1, v2 [8]byte, mirrored bool) (ux, lx, uz, lz bool) {
+	check := func(c1, c2 []int, a axis) bool {
+		return alignedRef(v1, c1, a) && alignedRef(v2, c2, a)
+	}
+	if !mirrored {
+		return check(allCorners, upperCorners, axisX), check(lowerCorners, allCorners, axisX),
+			check(allCorners, upperCorners, axisZ), check(lowerCorners, allCorners, axisZ)
+	}
+	return check(upperCorners, allCorners, axisX), check(allCorners, lowerCorners, axisX),
+		check(upperCorners, allCorners, axisZ), check(allCorners, lowerCorners, axisZ)
+}
+
+// validVectors maps every nibble of s onto a gradient index. Only the 12
+// indices in gradByte are ever produced by a perlin, the packed checks are
+// not meant to handle the remaining values.
+func validVectors(s uint64) uint64 {
+	var r uint64
+	for i := 0; i < 64; i += 4 {
+		r |= (((s >> i) & 0xF) % 12) << i
+	}
+	return r
+}
+
+// unpackVectors splits the result of getVectorsIntNoWrap into the vectors of
+// each noise.
+func unpackVectors(s uint64) (v1, v2 [8]byte) {
+	for i := 0; i < 8; i++ {
+		v1[i] = byte(s>>(60-4*i)) & 0xF
+		v2[i] = byte(s>>(28-4*i)) & 0xF
+	}
+	return v1, v2
+}
+
+// locFace identifies the candidate a location describes. The fit only depends
+// on the horizontal overlap of the cells and the height of the face.
+type locFace struct {
+	axis   axis
+	lo, hi [2]float64 // x and z of the overlap
+	y      float64
+}
+
+func faceOf(l noiseLocInfo) locFace {
+	return locFace{
+		l.axis,
+		[2]float64{math.Max(l.b1.lo.x, l.b2.lo.x), math.Max(l.b1.lo.z, l.b2.lo.z)},
+		[2]float64{math.Min(l.b1.hi.x, l.b2.hi.x), math.Min(l.b1.hi.z, l.b2.hi.z)},
+		l.y,
+	}
+}
+
+// scanAlignedCells finds candidate locations by sampling every integer
+// position of the search area with the packed vector checks.
+func scanAlignedCells(d noiseInfo, nn *normalNoise) (locs []noiseLocInfo) {
+	for x := searchMin; x <= searchMax; x++ {
+		for y := searchMin; y <= searchMax; y++ {
+			for z := searchMin; z <= searchMax; z++ {
+				c := coord{x, y, z}
+				v := nn.getVectorsIntNoWrap(c)
+				b1 := nn.boundsNoise1(c)
+				b2 := nn.boundsNoise2(c)
+				l1 := b1.lo.y > b2.lo.y
+				l2 := b1.hi.y > b2.hi.y
+				var ux, lx, uz, lz bool
+				var yu, yl float64
+				switch {
+				case l1 && l2:
+					ux, lx, uz, lz = is12AlignedVectorSetInt(v)
+					yu, yl = b2.hi.y, b1.lo.y
+				case !l1 && !l2:
+					ux, lx, uz, lz = is12AlignedVectorSetIntMirrored(v)
+					yu, yl = b1.hi.y, b2.lo.y
+				}
+				for _, r := range []struct {
+					a    axis
+					u, l bool
+				}{{axisX, ux, lx}, {axisZ, uz, lz}} {
+					if r.u {
+						locs = append(locs, noiseLocInfo{d.dimSeed, d.rl, r.a, b1, b2, yu})
+					} else if r.l {
+						locs = append(locs, noiseLocInfo{d.dimSeed, d.rl, r.a, b1, b2, yl})
+					}
+				}
+			}
+		}
+	}
+	return locs
+}
+
+// TestLatticeFaces compares the faces built from row rotations with the
+// gradients looked up at each lattice point.
+func TestLatticeFaces(t *testing.T) {
+	if testing.Short() {
+		t.Skip("checks every lattice point")
+	}
+	n := &seededNoise(12345, "syph:a").n1
+	f := newLatticeFaces(n)
+	g := func(x, y, z int) byte {
+		return n.pv[byte(int(n.p[byte(int(n.p[byte(x)])+y)])+z)]
+	}
+	count := [2]int{}
+	for x := 0; x < latticeSize; x++ {
+		for y := 0; y < latticeSize; y++ {
+			for z := 0; z < latticeSize; z++ {
+				var v [8]byte
+				v[0], v[1], v[4], v[5] = g(x, y, z), g(x+1, y, z), g(x, y, z+1), g(x+1, y, z+1)
+				for i, a := range [2]axis{axisX, axisZ} {
+					want := alignedRef(v, lowerCorners, a)
+					if got := f.face(a, int64(x), int64(y), int64(z)); got != want {
+						t.Fatalf("face %v at %d,%d,%d is %v, expected %v", a, x, y, z, got, want)
+					}
+					if want {
+						count[i]++
+					}
+				}
+			}
+		}
+	}
+	if count[0] == 0 || count[1] == 0 {
+		t.Errorf("expected aligned faces along both axes, found %v", count)
+	}
+}
+
+func TestAlignedCells(t *testing.T) {
+	if testing.Short() {
+		t.Skip("scans the whole search area")
+	}
+	// for seed 12345, scanning finds a cell in each of these
+	for _, rl := range []string{"syph:a", "syph:c", "syph:h"} {
+		d := noiseInfo{12345, rl}
+		nn := seededNoise(d.dimSeed, d.rl)
+		// the pairs of cells on both sides of a face describe the same
+		// candidate, which is enumerated once
+		found := make(map[locFace]bool)
+		locs := alignedCells(d, nn)
+		for _, l := range locs {
+			found[faceOf(l)] = true
+		}
+		scanned := scanAlignedCells(d, nn)
+		if len(scanned) == 0 {
+			t.Errorf("%s: no cells found by scanning", rl)
+		}
+		for _, l := range scanned {
+			if !found[faceOf(l)] {
+				t.Errorf("%s: cell found by scanning is missing: %+v", rl, l)
+			}
+		}
+		t.Logf("%s: %d cells enumerated, %d found by scanning", rl, len(locs), len(scanned))
+	}
+}
+
+func TestFitInsideAlignedCells(t *testing.T) {
+	// the noise only stays independent of the other axis inside the overlap
+	// of both cells, so the fit must be made and used there. syph:46 has a
+	// candidate whose cells overlap by less across the axis than the distance
+	// to their edges along it
+	n := 0
+	for _, rl := range []string{"syph:4", "syph:46"} {
+		d := noiseInfo{42, rl}
+		nn := seededNoise(d.dimSeed, d.rl)
+		for _, loc := range alignedCells(d, nn) {
+			p, ok := fitCandidate(nn, loc, genOptions{})
+			if !ok {
+				continue
+			}
+			n++
+			lo, hi, v := math.Max(loc.b1.lo.z, loc.b2.lo.z), math.Min(loc.b1.hi.z, loc.b2.hi.z), p.z
+			if p.axis == axisZ {
+				lo, hi, v = math.Max(loc.b1.lo.x, loc.b2.lo.x), math.Min(loc.b1.hi.x, loc.b2.hi.x), p.x
+			}
+			if !(v > lo && v < hi) {
+				t.Errorf("%s candidate fitted at %g, outside the cells from %g to %g", p.axis, v, lo, hi)
+			}
+			// positions within reach along the other axis stay in the cells too
+			if r := p.reach * p.scale; !(v-r >= lo-1e-12 && v+r <= hi+1e-12) {
+				t.Errorf("%s candidate at %g reaches %g across, outside the cells from %g to %g", p.axis, v, r, lo, hi)
+			}
+		}
+	}
+	if n == 0 {
+		t.Fatal("no candidates")
+	}
+}
+
+func TestNoDuplicateCells(t *testing.T) {
+	// the pairs of cells on either side of a face of either noise yield the
+	// same function, so only one of them is enumerated
+	n := 0
+	for i := int64(0); i < 40; i++ {
+		d := noiseInfo{42, namespace + ":" + strconv.FormatInt(i, 36)}
+		seen := make(map[locFace]bool)
+		for _, l := range alignedCells(d, seededNoise(d.dimSeed, d.rl)) {
+			n++
+			if seen[faceOf(l)] {
+				t.Errorf("%s: the face at %g is enumerated twice", d.rl, l.y)
+			}
+			seen[faceOf(l)] = true
+		}
+	}
+	if n == 0 {
+		t.Fatal("no candidates")
+	}
+}
+
+func TestIs12AlignedVectorSetNoise(t *testing.T) {
+	if testing.Short() {
+		t.Skip("scans the whole search area")
+	}
+	// for seed 0, syph:0 has an aligned cell where the first noise's cell is
+	// above the second's and syph:6 one where it is below
+	var found [2]int
+	for _, rl := range []string{"syph:0", "syph:6"} {
+		nn := seededNoise(0, rl)
+		for x := searchMin; x <= searchMax; x++ {
+			for y := searchMin; y <= searchMax; y++ {
+				for z := searchMin; z <= searchMax; z++ {
+					s := nn.getVectorsIntNoWrap(coord{x, y, z})
+					v1, v2 := unpackVectors(s)
+					for i, mirrored := range []bool{false, true} {
+						var ux, lx, uz, lz bool
+						if mirrored {
+							ux, lx, uz, lz = is12AlignedVectorSetIntMirrored(s)
+						} else {
+							ux, lx, uz, lz = is12AlignedVectorSetInt(s)
+						}
+						rux, rlx, ruz, rlz := is12AlignedRef(v1, v2, mirrored)
+						if ux != rux || lx != rlx || uz != ruz || lz != rlz {
+							t.Fatalf("%s mirrored %v at %v,%v,%v: got %v %v %v %v, reference %v %v %v %v",
+								rl, mirrored, x, y, z, ux, lx, uz, lz, rux, rlx, ruz, rlz)
+						}
+						if rux || rlx || ruz || rlz {
+							found[i]++
+						}
+					}
+				}
+			}
+		}
+	}
+	if found[0] == 0 || found[1] == 0 {
+		t.Errorf("expected aligned cells for both cases, found %v", found)
+	}
+}
+
+// checkPacked compares all packed checks with the reference for one vector set.
+func checkPacked(t *testing.T, s uint64) (aligned bool) {
+	v1, v2 := unpackVectors(s)
+
+	x, z := isAlignedVectorSet(v1, v2)
+	rx, rz := isAlignedRef(v1, v2)
+	if x != rx || z != rz {
+		t.Fatalf("isAlignedVectorSet(%v, %v) = %v %v, reference %v %v", v1, v2, x, z, rx, rz)
+	}
+	aligned = rx || rz
+
+	for _, mirrored := range []bool{false, true} {
+		var ux, lx, uz, lz bool
+		if mirrored {
+			ux, lx, uz, lz = is12AlignedVectorSetIntMirrored(s)
+		} else {
+			ux, lx, uz, lz = is12AlignedVectorSetInt(s)
+		}
+		rux, rlx, ruz, rlz := is12AlignedRef(v1, v2, mirrored)
+		if ux != rux || lx != rlx || uz != ruz || lz != rlz {
+			t.Fatalf("12 vector check of %016x, mirrored %v: got %v %v %v %v, reference %v %v %v %v",
+				s, mirrored, ux, lx, uz, lz, rux, rlx, ruz, rlz)
+		}
+		aligned = aligned || rux || rlx || ruz || rlz
+	}
+	return aligned
+}
+
+func FuzzAlignedVectorSet(f *testing.F) {
+	f.Add(uint64(0))                  // every vector 1,1,0
+	f.Add(uint64(0x8888888888888888)) // every vector 0,1,1
+	f.Add(uint64(0x0123012301230123)) // on the x-y plane, pairs along z equal
+	f.Add(uint64(0x8899aabb8899aabb)) // on the z-y plane, pairs along x equal
+	f.Add(uint64(0x0123456789ab0123))
+	f.Fuzz(func(t *testing.T, s uint64) {
+		checkPacked(t, validVectors(s))
+	})
+}
+
+// TestAlignedVectorSetRandom checks vector sets drawn mostly from vectors in
+// the planes the checks look for, so that many of them are aligned.
+func TestAlignedVectorSetRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	planar := []byte{0, 1, 8, 9}
+	aligned := 0
+	for i := 0; i < 1000000; i++ {
+		var v1, v2 [8]byte
+		for j := range v1 {
+			v1[j] = planar[r.Intn(len(planar))]
+			v2[j] = planar[r.Intn(len(planar))]
+		}
+		// copy corners across pairs to make the pair checks pass more often
+		for j := range v1 {
+			if r.Intn(2) == 0 {
+				v1[j] = v1[j&^4]
+				v2[j] = v2[j&^1]
+			}
+		}
+		if r.Intn(8) == 0 {
+			v1[r.Intn(8)] = byte(r.Intn(12))
+		}
+		if checkPacked(t, packVectors(v1, v2)) {
+			aligned++
+		}
+	}
+	if aligned == 0 {
+		t.Error("no aligned vector sets were generated")
+	}
+}
+
+func TestAmplifierFactors(t *testing.T) {
+	for _, scale := range []float64{1, 1e-3, 1e-9, 3e-10, 1e-20} {
+		f := amplifierFactors(scale)
+		for _, v := range f {
+			if v > maxConstant {
+				t.Errorf("scale %g: factor %g exceeds the constant limit", scale, v)
+			}
+		}
+		p := dfParams{scale: scale}
+		if a := p.amplifier(); math.Abs(a*scale-1) > 1e-15 {
+			t.Errorf("scale %g: amplifier %g is not its inverse", scale, a)
+		}
+	}
+	// round scales give round constants
+	if f := amplifierFactors(1e-9); !reflect.DeepEqual(f, []float64{1e6, 1000}) {
+		t.Errorf("scale 1e-9 split into %v", f)
+	}
+}
+
+func TestInputScale(t *testing.T) {
+	const scale = 2e-10
+	p := genFromDimSeed(context.Background(), 1, genOptions{scale: scale}, firstOfEachAxis)
+	if !p.okx || !p.okz {
+		t.Fatal("no parameters found")
+	}
+	files := fstest.MapFS{}
+	for name, d := range map[string]dfParams{"x": p.x, "z": p.z} {
+		if d.scale != scale {
+			t.Errorf("%s fitted for xz_scale %g, expected %g", name, d.scale, scale)
+		}
+		if !(d.reach > worldBorder) {
+			t.Errorf("%s usable within %g blocks, expected the whole world", name, d.reach)
+		}
+		b, err := json.Marshal(d.function())
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[namespace+"/worldgen/density_function/"+name+".json"] = &fstest.MapFile{Data: b}
+		ns, id := split(d.rl)
+		files[ns+"/worldgen/noise/"+id+".json"] = &fstest.MapFile{Data: []byte(noiseFile)}
+	}
+
+	l := newDfLoader(files, 1)
+	for name, d := range map[string]dfParams{"x": p.x, "z": p.z} {
+		f, err := l.load(namespace + ":" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nn := seededNoise(1, d.rl)
+		for _, c := range []intCoord{{0, 0, 0}, {12345, 64, -6789}, {-2.9e7, 0, 2.9e7}} {
+			want := d.value(nn, float64(c.x), float64(c.z))
+			if got := f.compute(c); got != want {
+				t.Errorf("%s at %v: evaluated %g, fitted function gives %g", name, c, got, want)
+			}
+		}
+	}
+}
+
+func TestProbesWithinReach(t *testing.T) {
+	// at a coarse scale the fit only holds near the origin, and the error is
+	// estimated there rather than at the world border
+	p := genFromDimSeed(context.Background(), 1, genOptions{scale: 1e-4}, firstOfEachAxis)
+	for _, d := range []dfParams{p.x, p.z} {
+		if !(d.reach > 0 && d.reach < worldBorder) {
+			t.Fatalf("%s usable within %g blocks", d.axis, d.reach)
+		}
+		ps := d.probes()
+		if ps[len(ps)-1] != d.reach {
+			t.Errorf("%s probed up to %g, usable within %g blocks", d.axis, ps[len(ps)-1], d.reach)
+		}
+		if !(d.err < 10) {
+			t.Errorf("%s error estimated as %g blocks within %g blocks", d.axis, d.err, d.reach)
+		}
+	}
+}
+
+func TestStreamedFits(t *testing.T) {
+	// the first candidate is sent without fitting the remaining ones
+	d := noiseInfo{42, "syph:4"}
+	n := 0
+	genFromNoiseInfo(context.Background(), d, genOptions{}, func(p dfParams) bool {
+		n++
+		return false
+	})
+	if n != 1 {
+		t.Errorf("%d candidates sent after the first was refused", n)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	genFromNoiseInfo(ctx, d, genOptions{}, func(p dfParams) bool {
+		t.Error("candidate sent after cancellation")
+		return true
+	})
+}
+
+func TestSearchWorkers(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	// with one worker the noises are searched in order
+	var got []dfParams
+	collect := func(a int, first bool, d dfParams) (int, bool) {
+		got = append(got, d)
+		return a + 1, a+1 < 4
+	}
+	genFromDimSeed(context.Background(), 1, genOptions{workers: 1}, collect)
+	var want []dfParams
+	for i := int64(0); len(want) < 4; i++ {
+		genFromNoiseInfo(context.Background(), noiseInfo{1, namespace + ":" + strconv.FormatInt(i, 36)}, genOptions{}, func(p dfParams) bool {
+			want = append(want, p)
+			return len(want) < 4
+		})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("one worker found %v, expected %v", got, want)
+	}
+
+	// more workers find them in the same order
+	got = nil
+	genFromDimSeed(context.Background(), 1, genOptions{workers: 4}, collect)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("four workers found %v, expected %v", got, want)
+	}
+
+	// a limited search gives up after its noises
+	got = nil
+	all := func(a int, first bool, d dfParams) (int, bool) {
+		got = append(got, d)
+		return a + 1, true
+	}
+	genFromDimSeed(context.Background(), 1, genOptions{workers: 4, noises: 3}, all)
+	want = nil
+	for i := int64(0); i < 3; i++ {
+		genFromNoiseInfo(context.Background(), noiseInfo{1, namespace + ":" + strconv.FormatInt(i, 36)}, genOptions{}, func(p dfParams) bool {
+			want = append(want, p)
+			return true
+		})
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("search of three noises found %v, expected %v", got, want)
+	}
+
+	// a cancelled search returns without candidates
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if n := genFromDimSeed(ctx, 1, genOptions{workers: 4}, collect); n != 0 {
+		t.Errorf("%d candidates after cancellation", n)
+	}
+
+	// the workers have stopped once the search returns
+	if n := runtime.NumGoroutine(); n > before {
+		time.Sleep(100 * time.Millisecond)
+		if n := runtime.NumGoroutine(); n > before {
+			t.Errorf("%d goroutines still running after the searches", n-before)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// derivativeStep is the step of the central differences reported by inspect.
+const derivativeStep = 1e-6
+
+// cornerNames are the corners in the order of perlin.vectors.
+var cornerNames = [8]string{"000", "100", "010", "110", "001", "101", "011", "111"}
+
+// inspection holds the internals of a noise at a position in noise space,
+// the input of normalNoise.getValue.
+type inspection struct {
+	seed       int64
+	rl         string
+	at         coord
+	octaves    [2]octaveInspection
+	value      float64
+	derivative coord
+
+	// how the second octave's cell lies relative to the first's, "below",
+	// "above" or "nested" if one contains the other vertically
+	vertical string
+	// results of isAlignedVectorSet for x and z
+	full [2]bool
+	// results of the 12 vector check matching vertical for x and z, the
+	// checks are not done for nested cells
+	upper, lower [2]bool
+	// the candidates the search would fit here
+	candidates []noiseLocInfo
+}
+
+type octaveInspection struct {
+	offset coord
+	at     coord       // input of the perlin, before wrapping
+	bounds coordBounds // of the cell, in noise space
+	// gradient indices at the corners
+	gradients [8]byte
+	value     float64
+}
+
+func inspectNoise(dimSeed int64, rl string, c coord) inspection {
+	nn := sharedNoises.get(dimSeed, rl)
+	in := inspection{seed: dimSeed, rl: rl, at: c, value: nn.getValue(c)}
+
+	v1, v2 := nn.getVectors(c)
+	b1, b2 := nn.boundsNoise1(c), nn.boundsNoise2(c)
+	in.octaves[0] = octaveInspection{nn.n1.o, c, b1, v1, nn.n1.noise(wrapCoord(c))}
+	in.octaves[1] = octaveInspection{nn.n2.o, scaleCoord(c), b2, v2, nn.n2.noise(wrapCoord(scaleCoord(c)))}
+
+	d := func(dx, dy, dz float64) float64 {
+		h := derivativeStep
+		lo := nn.getValue(coord{c.x - dx*h, c.y - dy*h, c.z - dz*h})
+		hi := nn.getValue(coord{c.x + dx*h, c.y + dy*h, c.z + dz*h})
+		return (hi - lo) / (2 * h)
+	}
+	in.derivative = coord{d(1, 0, 0), d(0, 1, 0), d(0, 0, 1)}
+
+	// classified the same way as the search does
+	in.full[0], in.full[1] = isAlignedVectorSet(v1, v2)
+	s := packVectors(v1, v2)
+	var yu, yl float64
+	switch l1, l2 := b1.lo.y > b2.lo.y, b1.hi.y > b2.hi.y; {
+	case l1 && l2:
+		in.vertical = "below"
+		in.upper[0], in.lower[0], in.upper[1], in.lower[1] = is12AlignedVectorSetInt(s)
+		yu, yl = b2.hi.y, b1.lo.y
+	case !l1 && !l2:
+		in.vertical = "above"
+		in.upper[0], in.lower[0], in.upper[1], in.lower[1] = is12AlignedVectorSetIntMirrored(s)
+		yu, yl = b1.hi.y, b2.lo.y
+	default:
+		in.vertical = "nested"
+	}
+	for i, a := range [2]axis{axisX, axisZ} {
+		if in.upper[i] {
+			in.candidates = append(in.candidates, noiseLocInfo{dimSeed, rl, a, b1, b2, yu})
+		} else if in.lower[i] {
+			in.candidates = append(in.candidates, noiseLocInfo{dimSeed, rl, a, b1, b2, yl})
+		}
+	}
+	return in
+}
+
+func formatCoord(c coord) string {
+	return fmt.Sprintf("%g,%g,%g", c.x, c.y, c.z)
+}
+
+func formatGradient(g byte) string {
+	v := gradients[g]
+	return fmt.Sprintf("(%d,%d,%d)", v[0], v[1], v[2])
+}
+
+func (in inspection) writeText(w io.Writer) {
+	fmt.Fprintf(w, "noise %s, seed %d, at %s\n", in.rl, in.seed, formatCoord(in.at))
+	fmt.Fprintf(w, "value %g, derivative %s\n", in.value, formatCoord(in.derivative))
+	for i, o := range in.octaves {
+		fmt.Fprintf(w, "octave %d: offset %s, input %s, value %g\n", i+1, formatCoord(o.offset), formatCoord(o.at), o.value)
+		fmt.Fprintf(w, "  cell %s to %s\n", formatCoord(o.bounds.lo), formatCoord(o.bounds.hi))
+		var g []string
+		for j, v := range o.gradients {
+			g = append(g, cornerNames[j]+" "+formatGradient(v))
+		}
+		fmt.Fprintf(w, "  gradients %s\n", strings.Join(g, " "))
+	}
+	fmt.Fprintf(w, "second cell %s, aligned x %v, z %v\n", in.vertical, in.full[0], in.full[1])
+	if in.vertical != "nested" {
+		fmt.Fprintf(w, "upper x %v, z %v, lower x %v, z %v\n", in.upper[0], in.upper[1], in.lower[0], in.lower[1])
+	}
+	for _, l := range in.candidates {
+		fmt.Fprintf(w, "candidate along %s at y %g\n", l.axis, l.y)
+	}
+}
+
+func (o octaveInspection) MarshalJSON() ([]byte, error) {
+	type corner struct {
+		Corner   string `json:"corner"`
+		Index    byte   `json:"index"`
+		Gradient [3]int `json:"gradient"`
+	}
+	corners := make([]corner, 8)
+	for i, v := range o.gradients {
+		corners[i] = corner{cornerNames[i], v, gradients[v]}
+	}
+	return json.Marshal(struct {
+		Offset  [3]jsonFloat            `json:"offset"`
+		Input   [3]jsonFloat            `json:"input"`
+		Bounds  map[string][3]jsonFloat `json:"bounds"`
+		Corners []corner                `json:"corners"`
+		Value   jsonFloat               `json:"value"`
+	}{jsonCoord(o.offset), jsonCoord(o.at), jsonBounds(o.bounds), corners, jsonFloat(o.value)})
+}
+
+func (in inspection) MarshalJSON() ([]byte, error) {
+	type axes struct {
+		X bool `json:"x"`
+		Z bool `json:"z"`
+	}
+	type candidate struct {
+		Axis string    `json:"axis"`
+		Y    jsonFloat `json:"y"`
+	}
+	candidates := make([]candidate, 0, len(in.candidates))
+	for _, l := range in.candidates {
+		candidates = append(candidates, candidate{l.axis.String(), jsonFloat(l.y)})
+	}
+	return json.Marshal(struct {
+		Seed       int64               `json:"seed"`
+		Noise      string              `json:"noise"`
+		At         [3]jsonFloat        `json:"at"`
+		Value      jsonFloat           `json:"value"`
+		Derivative [3]jsonFloat        `json:"derivative"`
+		Octaves    [2]octaveInspection `json:"octaves"`
+		Vertical   string              `json:"second_cell"`
+		Aligned    axes                `json:"aligned"`
+		Upper      axes                `json:"upper"`
+		Lower      axes                `json:"lower"`
+		Candidates []candidate         `json:"candidates"`
+	}{
+		in.seed, in.rl, jsonCoord(in.at), jsonFloat(in.value), jsonCoord(in.derivative), in.octaves,
+		in.vertical, axes{in.full[0], in.full[1]}, axes{in.upper[0], in.upper[1]}, axes{in.lower[0], in.lower[1]},
+		candidates,
+	})
+}
+
+func inspectMain(args []string) {
+	flags := flag.NewFlagSet("inspect", flag.ExitOnError)
+	seed := flags.Int64("seed", 0, "dimension `seed` the noise is constructed for (required)")
+	rl := flags.String("noise", "", "`resource location` of the noise (required)")
+	at := flags.String("at", "", "`x,y,z` position in noise space, the input of the noise (required)")
+	asJSON := flags.Bool("json", false, "print JSON instead of text")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: dfcoord inspect -seed <dimension seed> -noise <noise> -at <x,y,z> [-json]")
+		fmt.Fprintln(flags.Output(), "Prints the internals of a noise at a position.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 0 || !isFlagSet(flags, "seed") || *rl == "" || *at == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	c, err := parseFloats(*at, 3)
+	if err != nil {
+		log.Fatalf("invalid -at: %v", err)
+	}
+	in := inspectNoise(*seed, qualify(*rl), coord{c[0], c[1], c[2]})
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "\t")
+		if err := enc.Encode(in); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	in.writeText(os.Stdout)
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+// TestInspectCandidates checks that inspecting a position inside an
+// enumerated candidate reports the candidate.
+func TestInspectCandidates(t *testing.T) {
+	d := noiseInfo{12345, "syph:a"}
+	locs := alignedCells(d, seededNoise(d.dimSeed, d.rl))
+	if len(locs) == 0 {
+		t.Fatal("no candidates to inspect")
+	}
+	for _, l := range locs {
+		// the centre of the intersection of both cells
+		c := coord{
+			(math.Max(l.b1.lo.x, l.b2.lo.x) + math.Min(l.b1.hi.x, l.b2.hi.x)) / 2,
+			(math.Max(l.b1.lo.y, l.b2.lo.y) + math.Min(l.b1.hi.y, l.b2.hi.y)) / 2,
+			(math.Max(l.b1.lo.z, l.b2.lo.z) + math.Min(l.b1.hi.z, l.b2.hi.z)) / 2,
+		}
+		in := inspectNoise(d.dimSeed, d.rl, c)
+		found := false
+		for _, f := range in.candidates {
+			found = found || f == l
+		}
+		if !found {
+			t.Errorf("inspection at %v reports %+v, expected %+v", c, in.candidates, l)
+		}
+		if _, err := json.Marshal(in); err != nil {
+			t.Error(err)
+		}
+	}
+}
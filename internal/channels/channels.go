@@ -1,6 +1,9 @@
 package channels
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
 // Split spawns a goroutine copying data from the input channel to one or more
 // output channels. The output channels are closed when the input is closed.
@@ -101,3 +104,206 @@ func MergeS[T any](out chan<- T, c []chan T) {
 		close(out)
 	}()
 }
+
+// MergeCtx is equivalent to Merge except that it stops reading the input
+// channels and closes the output channel once ctx is done. Values read before
+// then but not yet sent are dropped.
+func MergeCtx[T any](ctx context.Context, out chan<- T, c ...<-chan T) {
+	merge := func(in <-chan T, w *sync.WaitGroup) {
+		defer w.Done()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case r, ok := <-in:
+				if !ok {
+					return
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case out <- r:
+				}
+			}
+		}
+	}
+
+	go func() {
+		var n sync.WaitGroup
+
+		n.Add(len(c))
+
+		for _, ch := range c {
+			go merge(ch, &n)
+		}
+		n.Wait()
+		close(out)
+	}()
+}
+
+// SplitCtx is equivalent to Split except that it stops reading the input
+// channel and closes the output channels once ctx is done. A value may then
+// have been sent to only some of the outputs.
+func SplitCtx[T any](ctx context.Context, in <-chan T, c ...chan<- T) {
+	go func() {
+		defer func() {
+			for _, ch := range c {
+				close(ch)
+			}
+		}()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				for _, ch := range c {
+					select {
+					case <-ctx.Done():
+						return
+					case ch <- v:
+					}
+				}
+			}
+		}
+	}()
+}
+
+// ParallelMap spawns n goroutines applying f to the values of the input
+// channel and returns a channel receiving the results in the order of their
+// inputs, with up to n+1 inputs in progress at once. The output channel is
+// closed when the input is closed and all results have been sent, or when ctx
+// is done, in both cases once the calls of f in progress have returned.
+func ParallelMap[T, U any](ctx context.Context, n int, in <-chan T, f func(T) U) <-chan U {
+	type item struct {
+		v T
+		r chan U
+	}
+	work := make(chan item)
+	// pending holds the result channels in the order of the inputs
+	pending := make(chan chan U, n)
+	out := make(chan U)
+
+	go func() {
+		defer close(pending)
+		defer close(work)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				r := make(chan U, 1)
+				select {
+				case <-ctx.Done():
+					return
+				case pending <- r:
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case work <- item{v, r}:
+				}
+			}
+		}
+	}()
+
+	var workers sync.WaitGroup
+	workers.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer workers.Done()
+			for it := range work {
+				it.r <- f(it.v)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(out)
+		defer workers.Wait()
+		for r := range pending {
+			select {
+			case <-ctx.Done():
+				return
+			case u := <-r:
+				select {
+				case <-ctx.Done():
+					return
+				case out <- u:
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// Take returns a channel receiving the first n values of the input channel.
+// It is closed after them, when the input is closed or when ctx is done. The
+// remaining values are left unread, the producer of the input is stopped by
+// other means, such as cancelling its context, or its values are drained.
+func Take[T any](ctx context.Context, in <-chan T, n int) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for i := 0; i < n; i++ {
+			select {
+			case <-ctx.Done():
+				return
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case out <- v:
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// Filter returns a channel receiving the values of the input channel for which
+// keep returns true. It is closed when the input is closed or when ctx is done.
+func Filter[T any](ctx context.Context, in <-chan T, keep func(T) bool) <-chan T {
+	out := make(chan T)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case v, ok := <-in:
+				if !ok {
+					return
+				}
+				if !keep(v) {
+					continue
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case out <- v:
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// Drain spawns a goroutine reading and discarding the values of a channel
+// until it is closed, so that the goroutines sending to it can finish once
+// the remaining values are no longer needed.
+func Drain[T any](in <-chan T) {
+	go func() {
+		for range in {
+		}
+	}()
+}
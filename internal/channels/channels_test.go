@@ -0,0 +1,198 @@
+package channels
+
+import (
+	"context"
+	"reflect"
+	"runtime"
+	"sort"
+	"testing"
+	"time"
+)
+
+// checkLeaks fails the test if more goroutines are running at its end than at
+// the call, after giving the stopped ones time to exit.
+func checkLeaks(t *testing.T) {
+	before := runtime.NumGoroutine()
+	t.Cleanup(func() {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			n := runtime.NumGoroutine()
+			if n <= before {
+				return
+			}
+			if time.Now().After(deadline) {
+				buf := make([]byte, 1<<16)
+				t.Errorf("%d goroutines leaked:\n%s", n-before, buf[:runtime.Stack(buf, true)])
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+}
+
+// count returns a channel receiving 0 to n-1, closed after them or once ctx is
+// done.
+func count(ctx context.Context, n int) <-chan int {
+	c := make(chan int)
+	go func() {
+		defer close(c)
+		for i := 0; i < n; i++ {
+			select {
+			case <-ctx.Done():
+				return
+			case c <- i:
+			}
+		}
+	}()
+	return c
+}
+
+func collect[T any](c <-chan T) []T {
+	var r []T
+	for v := range c {
+		r = append(r, v)
+	}
+	return r
+}
+
+func TestParallelMap(t *testing.T) {
+	checkLeaks(t)
+	got := collect(ParallelMap(context.Background(), 4, count(context.Background(), 100), func(i int) int {
+		// later inputs finish first unless the output is reordered
+		time.Sleep(time.Duration(100-i) * 10 * time.Microsecond)
+		return i * i
+	}))
+	if len(got) != 100 {
+		t.Fatalf("%d results, expected 100", len(got))
+	}
+	for i, v := range got {
+		if v != i*i {
+			t.Fatalf("result %d is %d, expected %d", i, v, i*i)
+		}
+	}
+}
+
+func TestParallelMapCancel(t *testing.T) {
+	checkLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	out := ParallelMap(ctx, 4, count(ctx, 1<<30), func(i int) int { return i })
+	for i := 0; i < 10; i++ {
+		if v := <-out; v != i {
+			t.Fatalf("result %d is %d", i, v)
+		}
+	}
+	cancel()
+	// the output is closed without being read
+	Drain(out)
+}
+
+func TestParallelMapWaits(t *testing.T) {
+	checkLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	release := make(chan struct{})
+	returned := false
+	out := ParallelMap(ctx, 1, count(ctx, 1), func(i int) int {
+		close(started)
+		<-release
+		returned = true
+		return i
+	})
+	<-started
+	cancel()
+	// the output stays open while f runs after the cancellation
+	select {
+	case <-out:
+		t.Fatal("output closed while f was running")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	for range out {
+	}
+	if !returned {
+		t.Error("output closed before f returned")
+	}
+}
+
+func TestTake(t *testing.T) {
+	checkLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	got := collect(Take(ctx, count(ctx, 1<<30), 5))
+	if !reflect.DeepEqual(got, []int{0, 1, 2, 3, 4}) {
+		t.Errorf("got %v", got)
+	}
+	if got := collect(Take(ctx, count(ctx, 3), 5)); len(got) != 3 {
+		t.Errorf("got %v from a shorter input", got)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	checkLeaks(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	even := Filter(ctx, count(ctx, 1<<30), func(i int) bool { return i%2 == 0 })
+	got := collect(Take(ctx, even, 4))
+	if !reflect.DeepEqual(got, []int{0, 2, 4, 6}) {
+		t.Errorf("got %v", got)
+	}
+	cancel()
+}
+
+func TestDrain(t *testing.T) {
+	checkLeaks(t)
+	c := make(chan int)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer close(c)
+		for i := 0; i < 100; i++ {
+			c <- i
+		}
+	}()
+	<-c
+	Drain(c)
+	<-done
+}
+
+func TestMergeCtx(t *testing.T) {
+	checkLeaks(t)
+	ctx := context.Background()
+	out := make(chan int)
+	MergeCtx(ctx, out, count(ctx, 10), count(ctx, 10))
+	got := collect(out)
+	sort.Ints(got)
+	for i, v := range got {
+		if v != i/2 {
+			t.Fatalf("got %v", got)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	out = make(chan int)
+	MergeCtx(ctx, out, count(ctx, 1<<30), count(ctx, 1<<30))
+	<-out
+	cancel()
+	Drain(out)
+}
+
+func TestSplitCtx(t *testing.T) {
+	checkLeaks(t)
+	ctx := context.Background()
+	a, b := make(chan int, 10), make(chan int, 10)
+	SplitCtx(ctx, count(ctx, 10), a, b)
+	if got := collect(a); len(got) != 10 {
+		t.Errorf("got %v", got)
+	}
+	if got := collect(b); len(got) != 10 {
+		t.Errorf("got %v", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a, b = make(chan int), make(chan int)
+	SplitCtx(ctx, count(ctx, 1<<30), a, b)
+	<-a
+	// the split is blocked sending to b, which it stops once cancelled
+	cancel()
+	Drain(a)
+	Drain(b)
+}
@@ -0,0 +1,147 @@
+package main
+
+import "math"
+
+// interval is a closed interval of reals. The operations round outwards, so
+// the result always contains the exact result for any values taken from the
+// operands.
+type interval struct {
+	lo, hi float64
+}
+
+func point(x float64) interval {
+	return interval{x, x}
+}
+
+func down(x float64) float64 {
+	return math.Nextafter(x, math.Inf(-1))
+}
+
+func up(x float64) float64 {
+	return math.Nextafter(x, math.Inf(1))
+}
+
+func (a interval) add(b interval) interval {
+	return interval{down(a.lo + b.lo), up(a.hi + b.hi)}
+}
+
+func (a interval) sub(b interval) interval {
+	return interval{down(a.lo - b.hi), up(a.hi - b.lo)}
+}
+
+func (a interval) mul(b interval) interval {
+	p := [4]float64{a.lo * b.lo, a.lo * b.hi, a.hi * b.lo, a.hi * b.hi}
+	r := interval{p[0], p[0]}
+	for _, v := range p[1:] {
+		r.lo = math.Min(r.lo, v)
+		r.hi = math.Max(r.hi, v)
+	}
+	return interval{down(r.lo), up(r.hi)}
+}
+
+// hull returns the smallest interval containing both a and b.
+func (a interval) hull(b interval) interval {
+	return interval{math.Min(a.lo, b.lo), math.Max(a.hi, b.hi)}
+}
+
+// intersect returns the common part of a and b, which must overlap.
+func (a interval) intersect(b interval) interval {
+	return interval{math.Max(a.lo, b.lo), math.Min(a.hi, b.hi)}
+}
+
+func (a interval) contains(x float64) bool {
+	return a.lo <= x && x <= a.hi
+}
+
+func (a interval) mid() float64 {
+	return a.lo + (a.hi-a.lo)/2
+}
+
+func (a interval) width() float64 {
+	return a.hi - a.lo
+}
+
+// mag returns the largest absolute value in a.
+func (a interval) mag() float64 {
+	return math.Max(math.Abs(a.lo), math.Abs(a.hi))
+}
+
+// dual is a value and its derivatives along two variables, all enclosed by
+// intervals, for forward mode automatic differentiation.
+type dual struct {
+	v interval
+	d [2]interval
+}
+
+func constDual(x float64) dual {
+	return dual{point(x), [2]interval{point(0), point(0)}}
+}
+
+// variable returns a dual for the values x of variable i.
+func variable(x interval, i int) dual {
+	d := constDual(0)
+	d.v = x
+	d.d[i] = point(1)
+	return d
+}
+
+func (a dual) add(b dual) dual {
+	return dual{a.v.add(b.v), [2]interval{a.d[0].add(b.d[0]), a.d[1].add(b.d[1])}}
+}
+
+func (a dual) sub(b dual) dual {
+	return dual{a.v.sub(b.v), [2]interval{a.d[0].sub(b.d[0]), a.d[1].sub(b.d[1])}}
+}
+
+func (a dual) mul(b dual) dual {
+	r := dual{v: a.v.mul(b.v)}
+	for i := range r.d {
+		r.d[i] = a.d[i].mul(b.v).add(a.v.mul(b.d[i]))
+	}
+	return r
+}
+
+func (a dual) scale(k float64) dual {
+	return dual{a.v.mul(point(k)), [2]interval{a.d[0].mul(point(k)), a.d[1].mul(point(k))}}
+}
+
+func (a dual) hull(b dual) dual {
+	return dual{a.v.hull(b.v), [2]interval{a.d[0].hull(b.d[0]), a.d[1].hull(b.d[1])}}
+}
+
+func (a dual) lerp(t, b dual) dual {
+	return a.add(t.mul(b.sub(a)))
+}
+
+// smoothStepInterval encloses smoothStep over x, which must lie within [0, 1]
+// where smoothStep is increasing.
+func smoothStepInterval(x interval) interval {
+	s := func(x float64) interval {
+		p := point(x)
+		// x*x*x*(x*(x*6-15)+10)
+		return p.mul(p).mul(p).mul(p.mul(p.mul(point(6)).sub(point(15))).add(point(10)))
+	}
+	return interval{s(x.lo).lo, s(x.hi).hi}
+}
+
+// smoothStepDerivInterval encloses 30x²(x-1)² over x within [0, 1], which
+// increases up to its maximum of 1.875 at 0.5 and decreases after.
+func smoothStepDerivInterval(x interval) interval {
+	s := func(x float64) interval {
+		p := point(x)
+		q := p.sub(point(1))
+		return point(30).mul(p.mul(p)).mul(q.mul(q))
+	}
+	lo, hi := s(x.lo), s(x.hi)
+	r := interval{math.Min(lo.lo, hi.lo), math.Max(lo.hi, hi.hi)}
+	if x.contains(0.5) {
+		r.hi = math.Max(r.hi, up(1.875))
+	}
+	return r
+}
+
+// smoothStepDual applies smoothStep to a dual within [0, 1].
+func smoothStepDual(x dual) dual {
+	d := smoothStepDerivInterval(x.v)
+	return dual{smoothStepInterval(x.v), [2]interval{d.mul(x.d[0]), d.mul(x.d[1])}}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"math"
+	"math/bits"
+)
+
+// The gradients of a perlin repeat every 256 lattice points along each axis
+// and whether a cell is aligned depends only on the gradients at its corners,
+// so rather than sampling positions, aligned cells can be found by
+// precomputing the aligned lattice faces of each noise once and enumerating
+// the pairs of overlapping cells built from them.
+
+const latticeSize = 256
+
+// latticeFaces holds, for both axes, a bitset of the lattice points whose
+// horizontal face, spanned by the point and its neighbours at +x, +z and
+// +x+z, has all four gradients on the plane of y and the axis with the
+// gradients paired along the other axis equal. Bits are indexed by
+// x<<16 | y<<8 | z.
+type latticeFaces struct {
+	x, z []uint64
+}
+
+// row256 is a bitset over one row of the lattice.
+type row256 [latticeSize / 64]uint64
+
+// rotate returns the row with bit i set to bit i+r of b, wrapping around.
+func (b row256) rotate(r int) (o row256) {
+	q, s := (r&0xFF)/64, uint(r%64)
+	for i := range o {
+		o[i] = b[(i+q)%4] >> s
+		if s != 0 {
+			o[i] |= b[(i+q+1)%4] << (64 - s)
+		}
+	}
+	return o
+}
+
+func (b row256) and(c row256) (o row256) {
+	for i := range o {
+		o[i] = b[i] & c[i]
+	}
+	return o
+}
+
+func newLatticeFaces(n *perlin) latticeFaces {
+	f := latticeFaces{make([]uint64, latticeSize*latticeSize*latticeSize/64), make([]uint64, latticeSize*latticeSize*latticeSize/64)}
+
+	// The gradients along a row of the lattice at x, y are n.pv rotated by
+	// n.p[n.p[x]+y], so every row is built from rotations of bitsets over pv.
+	var onXY, onZY row256         // same conditions as the checks in isAlignedVectorSet
+	var equal [latticeSize]row256 // bit i of equal[d] is set if pv[i] == pv[i+d]
+	for i, v := range n.pv {
+		if v&0xC == 0 {
+			onXY[i/64] |= 1 << (i % 64)
+		}
+		if v&0xC == 0x8 {
+			onZY[i/64] |= 1 << (i % 64)
+		}
+		for d := range equal {
+			if v == n.pv[(i+d)&0xFF] {
+				equal[d][i/64] |= 1 << (i % 64)
+			}
+		}
+	}
+
+	for x := 0; x < latticeSize; x++ {
+		for y := 0; y < latticeSize; y++ {
+			// rotations of the rows at x and x+1
+			r0 := int(n.p[byte(int(n.p[byte(x)])+y)])
+			r1 := int(n.p[byte(int(n.p[byte(x+1)])+y)])
+
+			// corners 000, 100, 001 and 101 of the face at z are in the rows
+			// at offsets z, z, z+1 and z+1
+			fx := onXY.rotate(r0).and(onXY.rotate(r1)).and(onXY.rotate(r0 + 1)).and(onXY.rotate(r1 + 1)).
+				and(equal[1].rotate(r0)).and(equal[1].rotate(r1))
+			d := (r1 - r0) & 0xFF
+			fz := onZY.rotate(r0).and(onZY.rotate(r1)).and(onZY.rotate(r0 + 1)).and(onZY.rotate(r1 + 1)).
+				and(equal[d].rotate(r0)).and(equal[d].rotate(r0 + 1))
+
+			base := (x<<16 | y<<8) / 64
+			copy(f.x[base:base+4], fx[:])
+			copy(f.z[base:base+4], fz[:])
+		}
+	}
+	return f
+}
+
+func (f latticeFaces) bits(a axis) []uint64 {
+	if a == axisX {
+		return f.x
+	}
+	return f.z
+}
+
+// face reports whether the face at lattice point x, y, z is aligned along a.
+// Coordinates wrap around the lattice.
+func (f latticeFaces) face(a axis, x, y, z int64) bool {
+	i := (x&0xFF)<<16 | (y&0xFF)<<8 | z&0xFF
+	return f.bits(a)[i/64]&(1<<(i%64)) != 0
+}
+
+// cellAlignment describes which faces of a cell are aligned along an axis.
+type cellAlignment struct {
+	lower, upper bool
+}
+
+func (c cellAlignment) full() bool {
+	return c.lower && c.upper
+}
+
+func (f latticeFaces) cell(a axis, l intCoord) cellAlignment {
+	return cellAlignment{f.face(a, l.x, l.y, l.z), f.face(a, l.x, l.y+1, l.z)}
+}
+
+// alignedCells returns every candidate location for the noise, made of a cell
+// of the first noise whose lower corner lies in the search area and a cell of
+// the second noise overlapping it, for which the noise does not depend on the
+// axis other than the one of the candidate at the returned height.
+func alignedCells(d noiseInfo, nn *normalNoise) (locs []noiseLocInfo) {
+	eachAlignedCell(d, nn, func(loc noiseLocInfo) bool {
+		locs = append(locs, loc)
+		return true
+	})
+	return locs
+}
+
+// eachAlignedCell calls yield with the locations of alignedCells as they are
+// found, stopping early and returning false once yield returns false.
+func eachAlignedCell(d noiseInfo, nn *normalNoise, yield func(loc noiseLocInfo) bool) bool {
+	f1 := newLatticeFaces(&nn.n1)
+	f2 := newLatticeFaces(&nn.n2)
+
+	// lattice range of the first noise, the same cells that contain the
+	// integer positions of the search area
+	lo := func(o float64) int64 { return int64(math.Floor(searchMin + o)) }
+	hi := func(o float64) int64 { return int64(math.Floor(searchMax + o)) }
+
+	for lx := lo(nn.n1.o.x); lx <= hi(nn.n1.o.x); lx++ {
+		for ly := lo(nn.n1.o.y); ly <= hi(nn.n1.o.y); ly++ {
+			i := (lx&0xFF)<<16 | (ly&0xFF)<<8
+			j := (lx&0xFF)<<16 | ((ly+1)&0xFF)<<8
+			for w := int64(0); w < latticeSize/64; w++ {
+				// cells with an aligned lower or upper face
+				m := f1.x[i/64+w] | f1.z[i/64+w] | f1.x[j/64+w] | f1.z[j/64+w]
+				for m != 0 {
+					z := w*64 + int64(bits.TrailingZeros64(m))
+					m &= m - 1
+					// the lattice repeats, so the row may hold the cell twice
+					for lz := lo(nn.n1.o.z) + (z-lo(nn.n1.o.z))&0xFF; lz <= hi(nn.n1.o.z); lz += latticeSize {
+						if !alignedPairs(d, nn, f1, f2, intCoord{lx, ly, lz}, lo(nn.n1.o.y), yield) {
+							return false
+						}
+					}
+				}
+			}
+		}
+	}
+	return true
+}
+
+// alignedPairs calls yield with the candidates made of the cell at lattice
+// position l1 of the first noise and the overlapping cells of the second,
+// returning false once yield returns false. ly0 is the lowest lattice y of the
+// first noise searched.
+func alignedPairs(d noiseInfo, nn *normalNoise, f1, f2 latticeFaces, l1 intCoord, ly0 int64, yield func(loc noiseLocInfo) bool) bool {
+	b1 := cellBounds1(nn, l1)
+
+	// lattice range of the second noise overlapping the cell
+	span := func(lo, hi, o float64) (int64, int64) {
+		return int64(math.Floor(lo*secondScale + o)), int64(math.Ceil(hi*secondScale+o)) - 1
+	}
+	x0, x1 := span(b1.lo.x, b1.hi.x, nn.n2.o.x)
+	y0, y1 := span(b1.lo.y, b1.hi.y, nn.n2.o.y)
+	z0, z1 := span(b1.lo.z, b1.hi.z, nn.n2.o.z)
+
+	for x := x0; x <= x1; x++ {
+		for y := y0; y <= y1; y++ {
+			for z := z0; z <= z1; z++ {
+				l2 := intCoord{x, y, z}
+				b2 := cellBounds2(nn, l2)
+				for _, a := range [2]axis{axisX, axisZ} {
+					a1, a2 := f1.cell(a, l1), f2.cell(a, l2)
+					face, y := alignedPlane(b1, b2, a1, a2)
+					switch face {
+					case noFace:
+						continue
+					// A lower face is the upper face of the cell below it, which
+					// pairs with the same cell of the other noise into the same
+					// candidate. It is only yielded from the cell below, unless
+					// that is not searched.
+					case lowerFirst:
+						below := intCoord{l1.x, l1.y - 1, l1.z}
+						if below.y >= ly0 {
+							if f, _ := alignedPlane(cellBounds1(nn, below), b2, f1.cell(a, below), a2); f == upperFirst {
+								continue
+							}
+						}
+					case lowerSecond:
+						below := intCoord{l2.x, l2.y - 1, l2.z}
+						if f, _ := alignedPlane(b1, cellBounds2(nn, below), a1, f2.cell(a, below)); f == upperSecond {
+							continue
+						}
+					}
+					if !yield(noiseLocInfo{d.dimSeed, d.rl, a, b1, b2, y}) {
+						return false
+					}
+				}
+			}
+		}
+	}
+	return true
+}
+
+// cellBounds1 and cellBounds2 return the bounds of the cells at a lattice
+// position of the first and second noise, computed by the same functions used
+// for sampled positions.
+func cellBounds1(nn *normalNoise, l intCoord) coordBounds {
+	return nn.boundsNoise1(coord{float64(l.x) - nn.n1.o.x + 0.5, float64(l.y) - nn.n1.o.y + 0.5, float64(l.z) - nn.n1.o.z + 0.5})
+}
+
+func cellBounds2(nn *normalNoise, l intCoord) coordBounds {
+	return nn.boundsNoise2(coord{(float64(l.x) - nn.n2.o.x + 0.5) / secondScale, (float64(l.y) - nn.n2.o.y + 0.5) / secondScale, (float64(l.z) - nn.n2.o.z + 0.5) / secondScale})
+}
+
+// cellFace identifies the horizontal face a candidate is fitted on.
+type cellFace int
+
+const (
+	noFace      cellFace = iota
+	lowerFirst           // the lower face of the first noise's cell
+	upperFirst           // the upper face of the first noise's cell
+	lowerSecond          // the lower face of the second noise's cell
+	upperSecond          // the upper face of the second noise's cell
+)
+
+// alignedPlane returns the face of the overlapping cells b1 and b2 with the
+// given alignments on which the noise does not depend on the other axis, and
+// its height, or noFace if there is none.
+func alignedPlane(b1, b2 coordBounds, a1, a2 cellAlignment) (cellFace, float64) {
+	if !overlaps(b1, b2) {
+		return noFace, 0
+	}
+	above := b1.lo.y > b2.lo.y
+	if above != (b1.hi.y > b2.hi.y) {
+		// one cell contains the other vertically
+		return noFace, 0
+	}
+	switch {
+	// the second noise's cell is below, matching is12AlignedVectorSetInt
+	case above && a1.full() && a2.upper:
+		return upperSecond, b2.hi.y
+	case above && a1.lower && a2.full():
+		return lowerFirst, b1.lo.y
+	// matching is12AlignedVectorSetIntMirrored
+	case !above && a1.upper && a2.full():
+		return upperFirst, b1.hi.y
+	case !above && a1.full() && a2.lower:
+		return lowerSecond, b2.lo.y
+	}
+	return noFace, 0
+}
+
+func overlaps(a, b coordBounds) bool {
+	return a.lo.x < b.hi.x && b.lo.x < a.hi.x &&
+		a.lo.y < b.hi.y && b.lo.y < a.hi.y &&
+		a.lo.z < b.hi.z && b.lo.z < a.hi.z
+}
@@ -0,0 +1,52 @@
+package main
+
+// legacyRandom is the game's LegacyRandomSource, the linear congruential
+// generator of java.util.Random.
+type legacyRandom struct {
+	seed int64
+}
+
+const (
+	legacyMultiplier = 0x5DEECE66D
+	legacyIncrement  = 0xB
+	legacyMask       = 1<<48 - 1
+)
+
+func newLegacyRandom(seed int64) *legacyRandom {
+	return &legacyRandom{(seed ^ legacyMultiplier) & legacyMask}
+}
+
+func (r *legacyRandom) next(bits int) int32 {
+	r.seed = (r.seed*legacyMultiplier + legacyIncrement) & legacyMask
+	return int32(r.seed >> (48 - bits))
+}
+
+func (r *legacyRandom) int32() int32 {
+	return r.next(32)
+}
+
+// boundedInt32 matches nextInt(bound) for a positive bound.
+func (r *legacyRandom) boundedInt32(bound int32) int32 {
+	if bound&(bound-1) == 0 {
+		return int32(int64(bound) * int64(r.next(31)) >> 31)
+	}
+	for {
+		j := r.next(31)
+		k := j % bound
+		// the game rejects values for which this overflows
+		if j-k+(bound-1) >= 0 {
+			return k
+		}
+	}
+}
+
+func (r *legacyRandom) float64() float64 {
+	// the game multiplies by 1.110223E-16F, which is exactly 2^-53 as a float
+	return float64(int64(r.next(26))<<27+int64(r.next(27))) * float64(float32(1.110223e-16))
+}
+
+func (r *legacyRandom) consumeCount(n int) {
+	for i := 0; i < n; i++ {
+		r.int32()
+	}
+}
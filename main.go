@@ -1,10 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"io/fs"
 	"log"
+	"math"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
@@ -14,79 +21,462 @@ type twoParams struct {
 	x, z     dfParams
 }
 
-// the file writing is a hack for now
+// subcommands maps the first argument to the command it runs. Arguments that
+// do not start with a subcommand are handled by generateMain.
+var subcommands = map[string]func(args []string){
+	"plot":    plotMain,
+	"eval":    evalMain,
+	"emit":    emitMain,
+	"check":   checkMain,
+	"inspect": inspectMain,
+	"merge":   mergeMain,
+	"serve":   serveMain,
+}
 
 func main() {
-	var err error
+	if len(os.Args) > 1 {
+		if cmd, ok := subcommands[os.Args[1]]; ok {
+			cmd(os.Args[2:])
+			return
+		}
+	}
+	generateMain(os.Args[1:])
+}
+
+// searchFlags holds the flags shared by every command that runs a search.
+type searchFlags struct {
+	rejects  string
+	maxError float64
+	noises   int
+	scale    float64
+	combine  int
+	exact    float64
+	shard    string
+	workers  int
+	s        shard // parsed shard
+}
+
+func (f *searchFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&f.rejects, "rejects", "", "write discarded candidates as JSON lines to `file`")
+	flags.Float64Var(&f.maxError, "max-error", 0, "discard candidates with an estimated error above `blocks`, 0 to disable")
+	flags.IntVar(&f.noises, "noises", 0, "give up after searching `n` noises, 0 for no limit")
+	flags.Float64Var(&f.scale, "xz-scale", defaultInputScale, "xz_scale of the emitted noises, trading `scale` against range")
+	flags.IntVar(&f.combine, "combine", 1, "add up to `n` candidates of each axis with weights cancelling their curvature")
+	flags.Float64Var(&f.exact, "exact", 0, "correct the functions with a spline to within 0.01 blocks up to `blocks` from the origin, 0 to disable")
+	flags.StringVar(&f.shard, "shard", "", "search only the noises whose index is k modulo n, given as `k/n`")
+	flags.IntVar(&f.workers, "j", 0, "search `n` noises at once, 0 for one per CPU")
+}
+
+// check validates the flags, so that bad values are reported before a search.
+func (f *searchFlags) check() {
+	if err := f.parse(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// parse validates the flags and parses those given as strings.
+func (f *searchFlags) parse() error {
+	if err := checkInputScale(f.scale); err != nil {
+		return fmt.Errorf("invalid -xz-scale: %v", err)
+	}
+	if f.combine < 1 || f.combine > maxCombine {
+		return fmt.Errorf("-combine must be between 1 and %d", maxCombine)
+	}
+	if err := checkRegion(f.exact); err != nil {
+		return fmt.Errorf("invalid -exact: %v", err)
+	}
+	if f.noises < 0 {
+		return errors.New("-noises must not be negative")
+	}
+	if f.workers < 0 {
+		return errors.New("-j must not be negative")
+	}
+	if f.shard != "" {
+		s, err := parseShard(f.shard)
+		if err != nil {
+			return fmt.Errorf("invalid -shard: %v", err)
+		}
+		f.s = s
+	}
+	return nil
+}
+
+// runSearch runs a search with the options of f, reducing the candidates with
+// rd, and reports the rejected candidates if requested.
+func runSearch[T any](f *searchFlags, dimSeed int64, rd func(a T, first bool, d dfParams) (T, bool)) (T, error) {
+	opts := genOptions{maxError: f.maxError, scale: f.scale, shard: f.s, workers: f.workers, noises: f.noises}
+
+	var a T
+	var rl *rejectLog
+	if f.rejects != "" {
+		file, err := os.Create(f.rejects)
+		if err != nil {
+			return a, err
+		}
+		defer file.Close()
+		rl = newRejectLog(file)
+		opts.reject = rl.record
+	}
 
-	if len(os.Args) < 2 || len(os.Args) > 2 {
-		log.Fatal("Usage: dfcoord <dimension seed>")
+	a = genFromDimSeed(context.Background(), dimSeed, opts, rd)
+
+	if rl != nil {
+		log.Printf("rejected candidates: %s", rl.summary())
+		if err := rl.writeError(); err != nil {
+			return a, err
+		}
 	}
+	return a, nil
+}
 
-	dimSeed, err := strconv.Atoi(os.Args[1])
+// search finds the parameters for each axis and logs them.
+func (f *searchFlags) search(dimSeed int64) twoParams {
+	f.check()
+	var t twoParams
+	var err error
+	if f.combine > 1 {
+		var pool candidatePool
+		if pool, err = runSearch(f, dimSeed, collectCandidates(combinePool)); err == nil {
+			t, err = pool.best(f.combine)
+		}
+	} else if t, err = runSearch(f, dimSeed, firstOfEachAxis); err == nil {
+		err = t.found()
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
+	if err := t.correct(f.exact); err != nil {
+		log.Fatal(err)
+	}
 
-	reduce := func(a twoParams, first bool, d dfParams) (twoParams, bool) {
-		if d.axis == axisX {
-			if !a.okx {
-				a.x = d
-				a.okx = true
-			}
-		} else {
-			if !a.okz {
-				a.z = d
-				a.okz = true
-			}
+	if t.okx {
+		logParams(t.x)
+	}
+	if t.okz {
+		logParams(t.z)
+	}
+	return t
+}
+
+// logParams logs the noise of p with its error, usable range and resolution.
+func logParams(p dfParams) {
+	reach := "unknown"
+	if p.reach != 0 {
+		reach = fmt.Sprintf("%.6g blocks", p.reach)
+	}
+	log.Printf("%s: %s at xz_scale %g, error %.6g blocks, usable within %s of the origin, steps of %.3g blocks",
+		p.axis, p.describe(), p.scale, p.err, reach, p.resolution())
+	if p.corr != nil {
+		log.Printf("%s: corrected to %.3g blocks within %g blocks of the origin with %d spline points",
+			p.axis, p.corr.maxErr, p.corr.region, len(p.corr.spline.locations))
+	}
+}
+
+// certify proves bounds on the error of both functions, logging them.
+func certify(t *twoParams) {
+	for _, p := range []*dfParams{&t.x, &t.z} {
+		if p.corr != nil {
+			log.Printf("%s: not certified, bounds of spline corrections are not supported", p.axis)
+			continue
+		}
+		ce, err := p.certifyError(sharedNoises.get(p.dimSeed, p.rl), defaultCertifyBoxes)
+		if err != nil {
+			log.Printf("%s: not certified, %v", p.axis, err)
+			continue
+		}
+		p.cert = ce.upper
+		log.Printf("%s: error at most %g blocks within %.6g blocks of the origin, %g found, after %d boxes", p.axis, ce.upper, p.certifyRange(), ce.lower, ce.boxes)
+	}
+}
+
+// found returns an error naming an axis without parameters, as when a search
+// gives up before finding candidates for both.
+func (t twoParams) found() error {
+	switch {
+	case !t.okx:
+		return errors.New("no candidates for x")
+	case !t.okz:
+		return errors.New("no candidates for z")
+	}
+	return nil
+}
+
+// firstOfEachAxis is a reduce callback for genFromDimSeed keeping the first
+// parameters found for each axis.
+func firstOfEachAxis(a twoParams, first bool, d dfParams) (twoParams, bool) {
+	if d.axis == axisX {
+		if !a.okx {
+			a.x = d
+			a.okx = true
+		}
+	} else {
+		if !a.okz {
+			a.z = d
+			a.okz = true
 		}
-		cont := !(a.okx && a.okz)
-		return a, cont
 	}
+	cont := !(a.okx && a.okz)
+	return a, cont
+}
 
-	t := genFromDimSeed(int64(dimSeed), reduce)
+// packFlags holds the flags shared by every command writing data pack files.
+type packFlags struct {
+	ns      string
+	derived bool
+	centre  string
+	seedOk  bool
+	units   string
+	origin  string
+	negate  bool
+	c       [2]float64      // parsed centre
+	u       []unitTransform // parsed units
+}
 
-	err = os.MkdirAll(fmt.Sprintf("%s/worldgen/density_function", namespace), fs.ModeDir+fs.ModePerm)
-	if err != nil {
+func (f *packFlags) register(flags *flag.FlagSet) {
+	flags.StringVar(&f.ns, "namespace", namespace, "`namespace` of the written density functions")
+	flags.BoolVar(&f.derived, "derived", false, "also write x_rel, z_rel, dist_sq, dist and quadrant functions")
+	flags.StringVar(&f.centre, "centre", "0,0", "`x,z` position the derived functions are relative to")
+	flags.BoolVar(&f.seedOk, "seed-ok", false, "also write seed_ok, which is 1 only in worlds with the intended seed")
+	flags.StringVar(&f.units, "units", "", "also write <unit>_x and <unit>_z for each of the comma separated `units` block, quart, chunk and region")
+	flags.StringVar(&f.origin, "origin", "0,0", "`x,z` block position the unit functions are relative to")
+	flags.BoolVar(&f.negate, "negate", false, "negate the unit functions")
+}
+
+// check validates the flags, so that bad values are reported before a search.
+func (f *packFlags) check() {
+	if err := f.parse(); err != nil {
 		log.Fatal(err)
 	}
+}
 
-	err = os.MkdirAll(fmt.Sprintf("%s/worldgen/noise", namespace), fs.ModeDir+fs.ModePerm)
+// parse validates the flags and parses those given as strings.
+func (f *packFlags) parse() error {
+	if f.ns == "" || strings.ContainsAny(f.ns, ":/") {
+		return fmt.Errorf("invalid -namespace %q", f.ns)
+	}
+	c, err := parseFloats(f.centre, 2)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("invalid -centre: %v", err)
+	}
+	if math.Abs(c[0]) > maxConstant || math.Abs(c[1]) > maxConstant {
+		return fmt.Errorf("-centre must be within %g blocks of the origin", maxConstant)
 	}
+	f.c = [2]float64{c[0], c[1]}
 
-	err = writeNoiseFile(split(t.x.rl))
+	units, err := parseUnits(f.units)
 	if err != nil {
-		log.Fatal(err)
+		return fmt.Errorf("invalid -units: %v", err)
 	}
+	o, err := parseFloats(f.origin, 2)
+	if err != nil {
+		return fmt.Errorf("invalid -origin: %v", err)
+	}
+	if (o[0] != 0 || o[1] != 0 || f.negate) && len(units) == 0 {
+		return errors.New("-origin and -negate only apply to the functions written for -units")
+	}
+	f.u = nil
+	for _, u := range units {
+		f.u = append(f.u, unitTransform{u, [2]float64{o[0], o[1]}, f.negate})
+	}
+	return nil
+}
+
+// write writes the noises and density functions for t into the folder dir.
+// The noises keep their own namespace, as they are seeded from their resource
+// location.
+func (f *packFlags) write(dir string, dimSeed int64, t twoParams) error {
+	dirs := []string{f.ns + "/worldgen/density_function"}
+	noises := append(t.x.noises(), t.z.noises()...)
+	for _, rl := range noises {
+		dirs = append(dirs, dfNamespace(rl)+"/worldgen/noise")
+	}
+	for _, d := range dirs {
+		err := os.MkdirAll(filepath.Join(dir, d), fs.ModeDir+fs.ModePerm)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, rl := range noises {
+		ns, id := split(rl)
+		if err := writeNoiseFile(dir, ns, id); err != nil {
+			return err
+		}
+	}
+
+	if err := writeDfFile(dir, f.ns, "x", t.x); err != nil {
+		return err
+	}
+	if err := writeDfFile(dir, f.ns, "z", t.z); err != nil {
+		return err
+	}
+
+	if f.derived {
+		for name, fn := range derivedFunctions(f.ns, f.c[0], f.c[1]) {
+			if err := writeDfJSON(dir, f.ns, name, fn); err != nil {
+				return err
+			}
+		}
+		n, err := validateDerived(os.DirFS(dir), dimSeed, f.ns, f.c[0], f.c[1])
+		if err != nil {
+			return fmt.Errorf("derived functions do not evaluate as expected: %v", err)
+		}
+		log.Printf("derived functions validated with %d evaluations", n)
+	}
+
+	for _, u := range f.u {
+		for _, p := range []dfParams{t.x, t.z} {
+			if err := writeDfFile(dir, f.ns, u.name(p.axis), u.apply(p)); err != nil {
+				return err
+			}
+		}
+	}
+	if len(f.u) > 0 {
+		n, err := validateUnits(os.DirFS(dir), dimSeed, f.ns, t, f.u)
+		if err != nil {
+			return fmt.Errorf("unit functions do not evaluate as expected: %v", err)
+		}
+		log.Printf("unit functions validated with %d evaluations", n)
+	}
+
+	if f.seedOk {
+		if err := writeDfJSON(dir, f.ns, "seed_ok", seedOkFunction(dimSeed, []string{t.x.rl, t.z.rl})); err != nil {
+			return err
+		}
+		if err := validateSeedOk(os.DirFS(dir), dimSeed, f.ns); err != nil {
+			return fmt.Errorf("seed_ok does not evaluate as expected: %v", err)
+		}
+	}
+	return nil
+}
+
+func dfNamespace(rl string) string {
+	ns, _ := split(rl)
+	return ns
+}
 
-	err = writeNoiseFile(split(t.z.rl))
+func generateMain(args []string) {
+	flags := flag.NewFlagSet("dfcoord", flag.ExitOnError)
+	var sf searchFlags
+	sf.register(flags)
+	var pf packFlags
+	pf.register(flags)
+	params := flags.String("params", "", "also write the found parameters to `file` for dfcoord emit")
+	candidates := flags.String("candidates", "", "write the first candidates of each axis to `file` for dfcoord merge instead of a pack")
+	count := flags.Int("count", combinePool, "number of candidates of each axis written with -candidates")
+	cert := flags.Bool("certify", false, "prove a bound on the error of each function over the world")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: dfcoord [flags] <dimension seed>")
+		fmt.Fprintln(flags.Output(), "       dfcoord plot [flags]")
+		fmt.Fprintln(flags.Output(), "       dfcoord eval [flags] <function> <x,y,z>...")
+		fmt.Fprintln(flags.Output(), "       dfcoord inspect [flags]")
+		fmt.Fprintln(flags.Output(), "       dfcoord emit [flags]")
+		fmt.Fprintln(flags.Output(), "       dfcoord check [flags] <pack folder or zip>")
+		fmt.Fprintln(flags.Output(), "       dfcoord merge [flags] <candidates.jsonl>...")
+		fmt.Fprintln(flags.Output(), "       dfcoord serve [flags]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	dimSeed, err := strconv.Atoi(flags.Arg(0))
 	if err != nil {
 		log.Fatal(err)
 	}
+	pf.check()
 
-	err = writeDfFile(namespace, "x", t.x)
-	if err != nil {
+	if *candidates != "" {
+		writeCandidatesFile(&sf, int64(dimSeed), *candidates, *count)
+		return
+	}
+
+	t := sf.search(int64(dimSeed))
+	if *cert {
+		certify(&t)
+	}
+
+	if *params != "" {
+		if err := writeReportFile(*params, int64(dimSeed), t); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := pf.write(".", int64(dimSeed), t); err != nil {
 		log.Fatal(err)
 	}
+}
 
-	err = writeDfFile(namespace, "z", t.z)
+// writeDfFile writes the function of p. A corrected function of an axis is
+// written together with its uncorrected function, which the corrections of
+// the axis in other units also refer to.
+func writeDfFile(dir, ns, name string, p dfParams) error {
+	if p.corr == nil {
+		return writeDfJSON(dir, ns, name, p.function())
+	}
+	var linear any = p.uncached()
+	if name == p.axis.String() {
+		if err := writeDfJSON(dir, ns, linearName(p.axis), p.function()); err != nil {
+			return err
+		}
+		linear = ns + ":" + linearName(p.axis)
+	}
+	return writeDfJSON(dir, ns, name, p.correctedFunction(linear, ns+":"+linearName(p.axis)))
+}
+
+func writeDfJSON(dir, ns, name string, v any) error {
+	b, err := json.MarshalIndent(v, "", "\t")
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	return os.WriteFile(filepath.Join(dir, ns, "worldgen", "density_function", name+".json"), b, 0644)
+}
 
-	return
+// jsonObject is a JSON object that keeps its keys in order, so that written
+// density functions list their type first.
+type jsonObject []jsonField
 
+type jsonField struct {
+	key   string
+	value any
 }
 
-func writeDfFile(ns string, name string, p dfParams) error {
-	s := fmt.Sprintf(dfFormat, p.b, p.m, p.rl, p.x, p.y, p.z)
-	return os.WriteFile(fmt.Sprintf("%s/worldgen/density_function/%s.json", ns, name), []byte(s), 0644)
+// object builds a jsonObject from alternating keys and values.
+func object(kv ...any) jsonObject {
+	o := make(jsonObject, 0, len(kv)/2)
+	for i := 0; i+1 < len(kv); i += 2 {
+		o = append(o, jsonField{kv[i].(string), kv[i+1]})
+	}
+	return o
 }
 
-func writeNoiseFile(ns string, name string) error {
-	return os.WriteFile(fmt.Sprintf("%s/worldgen/noise/%s.json", ns, name), []byte(noiseFile), 0644)
+func (o jsonObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		k, err := json.Marshal(f.key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(k)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+func writeNoiseFile(dir, ns, name string) error {
+	return os.WriteFile(filepath.Join(dir, ns, "worldgen", "noise", name+".json"), []byte(noiseFile), 0644)
 }
 
 func split(rl string) (namespace string, id string) {
@@ -100,32 +490,3 @@ var noiseFile = `{
         1.0
     ]
 }`
-
-var dfFormat = `{
-	"type": "minecraft:flat_cache",
-	"argument": {
-		"type": "minecraft:mul",
-		"argument1": {
-			"type": "minecraft:mul",
-			"argument1": 1.0e6,
-			"argument2": 1.0e3
-		},
-		"argument2": {
-			"type": "minecraft:add",
-			"argument1": %.14g,
-			"argument2": {
-				"type": "minecraft:mul",
-				"argument1": %.14g,
-				"argument2": {
-					"type": "minecraft:shifted_noise",
-					"noise": "%s",
-					"xz_scale": 1.0e-9,
-					"y_scale": 0.0,
-					"shift_x": %.14g,
-					"shift_y": %.14g,
-					"shift_z": %.14g
-				}
-			}
-		}
-	}
-}`
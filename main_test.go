@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"testing"
 )
 
@@ -78,5 +79,5 @@ func BenchmarkFromDimSeed(b *testing.B) {
 		return a, cont
 	}
 
-	_ = genFromDimSeed(int64(dimSeed), reduce)
+	_ = genFromDimSeed(context.Background(), int64(dimSeed), genOptions{}, reduce)
 }
@@ -1,5 +1,7 @@
 package main
 
+import "math"
+
 func lerp(x, a, b float64) float64 {
 	return a + x*(b-a)
 }
@@ -31,3 +33,64 @@ func newtonRoot(init float64, iter int, f func(x float64) float64, df func(x flo
 	}
 	return x
 }
+
+// javaFloor matches Mth.floor, which converts to an int with the saturating
+// semantics of java casts.
+func javaFloor(x float64) int32 {
+	f := math.Floor(x)
+	switch {
+	case f != f:
+		return 0
+	case f >= math.MaxInt32:
+		return math.MaxInt32
+	case f <= math.MinInt32:
+		return math.MinInt32
+	}
+	return int32(f)
+}
+
+// clampedLerp matches Mth.clampedLerp, returning a below 0 and b above 1.
+func clampedLerp(a, b, t float64) float64 {
+	switch {
+	case t < 0:
+		return a
+	case t > 1:
+		return b
+	}
+	return lerp(t, a, b)
+}
+
+// solveLinear solves the square system a x = b by gaussian elimination with
+// partial pivoting, overwriting a and b. It reports false if a is singular.
+func solveLinear(a [][]float64, b []float64) ([]float64, bool) {
+	n := len(b)
+	for col := 0; col < n; col++ {
+		pivot := col
+		for r := col + 1; r < n; r++ {
+			if math.Abs(a[r][col]) > math.Abs(a[pivot][col]) {
+				pivot = r
+			}
+		}
+		if a[pivot][col] == 0 {
+			return nil, false
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		b[col], b[pivot] = b[pivot], b[col]
+		for r := col + 1; r < n; r++ {
+			f := a[r][col] / a[col][col]
+			for c := col; c < n; c++ {
+				a[r][c] -= f * a[col][c]
+			}
+			b[r] -= f * b[col]
+		}
+	}
+	x := make([]float64, n)
+	for r := n - 1; r >= 0; r-- {
+		v := b[r]
+		for c := r + 1; c < n; c++ {
+			v -= a[r][c] * x[c]
+		}
+		x[r] = v / a[r][r]
+	}
+	return x, true
+}
@@ -9,9 +9,6 @@ import (
 // there's no need to reimplement the rest. If this file is created into a separate
 // package then I suppose it may make sense to do so.
 
-// todo: pass pointers to noises (or at least to perlin's arrays) instead of values,
-// copying large arrays is expensive
-
 const (
 	octaveStr   = "octave_0"
 	secondScale = 1.0181268882175227
@@ -24,24 +21,29 @@ type normalNoise struct {
 	valueFactor float64
 }
 
-func newNormalNoise(r xoroshiro) normalNoise {
+func newNormalNoise(r xoroshiro) *normalNoise {
 	n1 := newPerlin(r.forkFixed().fromHash(octaveStr))
 	n2 := newPerlin(r.forkFixed().fromHash(octaveStr))
 
-	return normalNoise{n1, n2, vf}
+	return &normalNoise{n1, n2, vf}
+}
+
+// seededNoise constructs the noise the game creates for rl in a world with the given seed.
+func seededNoise(dimSeed int64, rl string) *normalNoise {
+	return newNormalNoise(newXoroshiro(upgradeSeedTo128Bit(dimSeed)).forkFixed().fromHash(rl))
 }
 
-func (n normalNoise) boundsNoise1(c coord) coordBounds {
+func (n *normalNoise) boundsNoise1(c coord) coordBounds {
 	return n.n1.cuboidBounds(wrapCoord(c))
 }
 
-func (n normalNoise) boundsNoise2(c coord) coordBounds {
+func (n *normalNoise) boundsNoise2(c coord) coordBounds {
 	b := n.n2.cuboidBounds(wrapCoord(scaleCoord(c)))
 	return coordBounds{descaleCoord(b.lo), descaleCoord(b.hi)}
 }
 
 // cuboidBounds returns the intersection of the bounds of the two noises
-func (n normalNoise) cuboidBounds(c coord) (cbr coordBounds) {
+func (n *normalNoise) cuboidBounds(c coord) (cbr coordBounds) {
 	cb1 := n.boundsNoise1(c)
 	cb2 := n.boundsNoise2(c)
 
@@ -56,13 +58,13 @@ func (n normalNoise) cuboidBounds(c coord) (cbr coordBounds) {
 	return cbr
 }
 
-func (n normalNoise) getValue(c coord) float64 {
+func (n *normalNoise) getValue(c coord) float64 {
 	v1 := n.n1.noise(wrapCoord(c))
 	v2 := n.n2.noise(wrapCoord(scaleCoord(c)))
 	return (v1 + v2) * vf
 }
 
-func (n normalNoise) getVectors(c coord) ([8]byte, [8]byte) {
+func (n *normalNoise) getVectors(c coord) ([8]byte, [8]byte) {
 	var c1, c2 coord
 
 	c1 = wrapCoord(c)
@@ -71,11 +73,21 @@ func (n normalNoise) getVectors(c coord) ([8]byte, [8]byte) {
 	return n.n1.vectors(c1), n.n2.vectors(c2)
 }
 
-func (n normalNoise) getVectorsIntNoWrap(c coord) uint64 {
+func (n *normalNoise) getVectorsIntNoWrap(c coord) uint64 {
 	return (uint64(n.n1.vectorsInt(c)) << 32) | uint64(n.n2.vectorsInt(scaleCoord(c)))
 }
 
-func (n normalNoise) getNoiseCoords(c coord) (coord, coord) {
+// packVectors packs the vectors of both noises like getVectorsIntNoWrap.
+func packVectors(v1, v2 [8]byte) uint64 {
+	var s uint64
+	for i := 0; i < 8; i++ {
+		s |= uint64(v1[i]) << (60 - 4*i)
+		s |= uint64(v2[i]) << (28 - 4*i)
+	}
+	return s
+}
+
+func (n *normalNoise) getNoiseCoords(c coord) (coord, coord) {
 	var c1, c2 coord
 
 	c1.x = wrap(c.x + n.n1.o.x)
@@ -159,13 +171,20 @@ func compGradients(rn [256]byte) [256]byte {
 	return pr
 }
 
-func (n perlin) noise(c coord) float64 {
-	// assumes zero lfif, lfvf, y
+func (n *perlin) noise(c coord) float64 {
+	return n.noiseFull(c.x, c.y, c.z, 0, 0)
+}
 
+// noiseFull matches ImprovedNoise.noise, including the yScale and yMax terms
+// used by the old blended noise. A non-zero yScale flattens the noise along y
+// by offsetting the y of the dot products, but not of the smoothing, down to a
+// multiple of yScale, from the fractional y or yMax if that is non-negative and
+// smaller.
+func (n *perlin) noiseFull(x, y, z, yScale, yMax float64) float64 {
 	var oc coord
-	oc.x = c.x + n.o.x
-	oc.y = c.y + n.o.y
-	oc.z = c.z + n.o.z
+	oc.x = x + n.o.x
+	oc.y = y + n.o.y
+	oc.z = z + n.o.z
 
 	var ob intCoord
 	ob.x = int64(math.Floor(oc.x))
@@ -177,6 +196,16 @@ func (n perlin) noise(c coord) float64 {
 	of.y = oc.y - float64(ob.y)
 	of.z = oc.z - float64(ob.z)
 
+	var yOff float64
+	if yScale != 0 {
+		p := of.y
+		if yMax >= 0 && yMax < of.y {
+			p = yMax
+		}
+		// the game adds 1.0E-7F, a float
+		yOff = float64(javaFloor(p/yScale+float64(float32(1e-7)))) * yScale
+	}
+
 	// some random numbers
 	r := func(i int) int {
 		return int(n.p[i&0xFF] & 0xFF)
@@ -191,7 +220,7 @@ func (n perlin) noise(c coord) float64 {
 	rxy1 := r(rx + yb + 1)
 	rx1y1 := r(rx1 + yb + 1)
 
-	xf, yf, zf := of.x, of.y, of.z
+	xf, yf, zf := of.x, of.y-yOff, of.z
 
 	// dot products
 	ov000 := gradDot(r(rxy+zb), xf, yf, zf)
@@ -203,15 +232,15 @@ func (n perlin) noise(c coord) float64 {
 	ov011 := gradDot(r(rxy1+zb+1), xf, yf-1, zf-1)
 	ov111 := gradDot(r(rx1y1+zb+1), xf-1, yf-1, zf-1)
 
-	// smooth
+	// smooth, using the fractional y before flattening
 	xfs := smoothStep(xf)
-	yfs := smoothStep(yf)
+	yfs := smoothStep(of.y)
 	zfs := smoothStep(zf)
 
 	return lerp3(xfs, yfs, zfs, ov000, ov100, ov010, ov110, ov001, ov101, ov011, ov111)
 }
 
-func (n perlin) cuboidBounds(c coord) (b coordBounds) {
+func (n *perlin) cuboidBounds(c coord) (b coordBounds) {
 	var of coord
 
 	of.x = n.o.x - math.Floor(n.o.x)
@@ -233,7 +262,7 @@ func r(p [256]byte, i int) int {
 	return int(p[i&0xFF] & 0xFF)
 }
 
-func (n perlin) vectors(c coord) [8]byte {
+func (n *perlin) vectors(c coord) [8]byte {
 	var oc coord
 	var ob intCoord
 
@@ -271,7 +300,7 @@ func (n perlin) vectors(c coord) [8]byte {
 	return [8]byte{ov000, ov100, ov010, ov110, ov001, ov101, ov011, ov111}
 }
 
-func (n perlin) vectorsInt(c coord) uint32 {
+func (n *perlin) vectorsInt(c coord) uint32 {
 	var oc coord
 	var ob intCoord
 
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// noiseFullRef evaluates ImprovedNoise.noise as a weighted sum over the cell
+// corners, with the gradients taken from perlin.vectors.
+func noiseFullRef(n *perlin, x, y, z, yScale, yMax float64) float64 {
+	v := n.vectors(coord{x, y, z})
+	fx := x + n.o.x - math.Floor(x+n.o.x)
+	fy := y + n.o.y - math.Floor(y+n.o.y)
+	fz := z + n.o.z - math.Floor(z+n.o.z)
+
+	q := 0.0
+	if yScale != 0 {
+		p := fy
+		if yMax >= 0 && yMax < fy {
+			p = yMax
+		}
+		q = math.Floor(p/yScale+float64(float32(1e-7))) * yScale
+	}
+
+	w := [3]float64{smoothStep(fx), smoothStep(fy), smoothStep(fz)}
+	var sum float64
+	for c := 0; c < 8; c++ {
+		corner := [3]float64{float64(c & 1), float64(c >> 1 & 1), float64(c >> 2 & 1)}
+		weight := 1.0
+		for i, b := range corner {
+			if b == 1 {
+				weight *= w[i]
+			} else {
+				weight *= 1 - w[i]
+			}
+		}
+		g := gradients[v[c]]
+		sum += weight * (float64(g[0])*(fx-corner[0]) + float64(g[1])*(fy-q-corner[1]) + float64(g[2])*(fz-corner[2]))
+	}
+	return sum
+}
+
+func TestNoiseFull(t *testing.T) {
+	n := &seededNoise(0, "syph:a").n1
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		x, y, z := r.Float64()*512-256, r.Float64()*512-256, r.Float64()*512-256
+
+		// without a yScale, or with one too large to flatten, the result is
+		// exactly that of noise
+		want := n.noise(coord{x, y, z})
+		for _, ys := range []float64{0, 1.5, 2} {
+			if got := n.noiseFull(x, y, z, ys, -1); got != want {
+				t.Fatalf("noiseFull(%v, %v, %v, %v, -1) = %v, noise gives %v", x, y, z, ys, got, want)
+			}
+		}
+
+		for _, ys := range []float64{0, 1.0 / 3, 0.25, 0.01} {
+			for _, ym := range []float64{-1, 0, 0.3, 2} {
+				got := n.noiseFull(x, y, z, ys, ym)
+				ref := noiseFullRef(n, x, y, z, ys, ym)
+				if math.Abs(got-ref) > 1e-12 {
+					t.Fatalf("noiseFull(%v, %v, %v, %v, %v) = %v, reference %v", x, y, z, ys, ym, got, ref)
+				}
+			}
+		}
+	}
+}
+
+// TestNoiseFullFlattening checks that the dot products are only offset once
+// the fractional y, clamped to yMax, reaches the first multiple of yScale.
+func TestNoiseFullFlattening(t *testing.T) {
+	n := &seededNoise(0, "syph:a").n1
+	r := rand.New(rand.NewSource(2))
+	const ys = 0.25
+	for i := 0; i < 1000; i++ {
+		x, z := r.Float64()*512-256, r.Float64()*512-256
+		// fractional y of about 0.1 and 0.6
+		y := math.Floor(r.Float64()*512-256) - n.o.y + 0.1
+		if a, b := n.noiseFull(x, y, z, ys, -1), n.noise(coord{x, y, z}); a != b {
+			t.Fatalf("noise within the first step at %v is %v, expected %v", y, a, b)
+		}
+		if a, b := n.noiseFull(x, y+0.5, z, ys, 0.1), n.noise(coord{x, y + 0.5, z}); a != b {
+			t.Fatalf("noise clamped to the first step at %v is %v, expected %v", y+0.5, a, b)
+		}
+		if a, b := n.noiseFull(x, y+0.5, z, ys, -1), n.noise(coord{x, y + 0.5, z}); a == b {
+			t.Fatalf("noise beyond the first step at %v is not flattened", y+0.5)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+)
+
+// noiseCache keeps the most recently used noises so that commands evaluating
+// many functions or seeds do not construct the same noise repeatedly. It is
+// safe for concurrent use.
+type noiseCache struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List // of noiseCacheEntry, most recently used first
+	items map[noiseInfo]*list.Element
+}
+
+type noiseCacheEntry struct {
+	key noiseInfo
+	nn  *normalNoise
+}
+
+func newNoiseCache(size int) *noiseCache {
+	return &noiseCache{size: size, order: list.New(), items: make(map[noiseInfo]*list.Element)}
+}
+
+// sharedNoises is used by the evaluator and the commands built on it.
+var sharedNoises = newNoiseCache(64)
+
+// get returns the noise for rl in a world with the given seed, constructing it
+// if it is not cached.
+func (c *noiseCache) get(dimSeed int64, rl string) *normalNoise {
+	key := noiseInfo{dimSeed, rl}
+	c.mu.Lock()
+	if e, ok := c.items[key]; ok {
+		c.order.MoveToFront(e)
+		c.mu.Unlock()
+		return e.Value.(noiseCacheEntry).nn
+	}
+	c.mu.Unlock()
+
+	// constructed without holding the lock, another caller may have added the
+	// same noise in the meantime
+	nn := seededNoise(dimSeed, rl)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.order.MoveToFront(e)
+		return e.Value.(noiseCacheEntry).nn
+	}
+	c.items[key] = c.order.PushFront(noiseCacheEntry{key, nn})
+	for c.order.Len() > c.size {
+		e := c.order.Back()
+		c.order.Remove(e)
+		delete(c.items, e.Value.(noiseCacheEntry).key)
+	}
+	return nn
+}
+
+func (c *noiseCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
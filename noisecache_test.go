@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestNoiseCache(t *testing.T) {
+	c := newNoiseCache(2)
+	a := c.get(1, "syph:a")
+	if c.get(1, "syph:a") != a {
+		t.Error("cached noise was constructed again")
+	}
+	if c.get(2, "syph:a") == a {
+		t.Error("noises of different seeds share an entry")
+	}
+	if a.n1.o != seededNoise(1, "syph:a").n1.o {
+		t.Error("cached noise differs from a newly constructed one")
+	}
+
+	// use syph:a of seed 1 again, so that the noise of seed 2 is evicted
+	c.get(1, "syph:a")
+	c.get(1, "syph:b")
+	if c.len() != 2 {
+		t.Errorf("cache holds %d noises, expected 2", c.len())
+	}
+	if c.get(1, "syph:a") != a {
+		t.Error("most recently used noise was evicted")
+	}
+}
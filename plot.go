@@ -0,0 +1,288 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func plotMain(args []string) {
+	flags := flag.NewFlagSet("plot", flag.ExitOnError)
+	var sf searchFlags
+	sf.register(flags)
+	seed := flags.Int64("seed", 0, "dimension `seed` (required)")
+	axisName := flags.String("axis", "x", "plot the function for `axis` x or z")
+	rect := flags.String("rect", "-3.2e7,-3.2e7,3.2e7,3.2e7", "plotted area as `x0,z0,x1,z1` in blocks")
+	width := flags.Int("width", 512, "image width in `pixels`")
+	height := flags.Int("height", 512, "image height in `pixels`")
+	out := flags.String("o", "", "output `file`, defaults to error_<axis>.png")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: dfcoord plot -seed <dimension seed> [flags]")
+		fmt.Fprintln(flags.Output(), "Renders the absolute error of a generated coordinate function as a PNG.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 0 || !isFlagSet(flags, "seed") {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	var a axis
+	switch *axisName {
+	case "x":
+		a = axisX
+	case "z":
+		a = axisZ
+	default:
+		log.Fatalf("unknown axis %q", *axisName)
+	}
+
+	r, err := parseFloats(*rect, 4)
+	if err != nil {
+		log.Fatalf("invalid -rect: %v", err)
+	}
+	if *width <= 0 || *height <= 0 {
+		log.Fatal("image size must be positive")
+	}
+	if *out == "" {
+		*out = "error_" + a.String() + ".png"
+	}
+
+	t := sf.search(*seed)
+	p := t.x
+	if a == axisZ {
+		p = t.z
+	}
+
+	e := errorMap(p, sharedNoises.get(p.dimSeed, p.rl), r[0], r[1], r[2], r[3], *width, *height)
+	img := e.render()
+
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%s: %s, error %.6g to %.6g blocks\n", *out, p.rl, e.min, e.max)
+}
+
+// isFlagSet reports whether the named flag was given on the command line.
+func isFlagSet(flags *flag.FlagSet, name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// parseFloats parses exactly n comma separated numbers.
+func parseFloats(s string, n int) ([]float64, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != n {
+		return nil, fmt.Errorf("expected %d comma separated numbers, got %d", n, len(parts))
+	}
+	r := make([]float64, n)
+	for i, v := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return nil, err
+		}
+		r[i] = f
+	}
+	return r, nil
+}
+
+// heatmap holds the absolute error of a function sampled at the centre of
+// each pixel of an image, row by row.
+type heatmap struct {
+	w, h           int
+	x0, z0, x1, z1 float64
+	v              []float64
+	min, max       float64 // range of the finite values
+}
+
+func errorMap(p dfParams, nn *normalNoise, x0, z0, x1, z1 float64, w, h int) heatmap {
+	m := heatmap{w, h, x0, z0, x1, z1, make([]float64, w*h), math.Inf(1), math.Inf(-1)}
+	for j := 0; j < h; j++ {
+		z := z0 + (float64(j)+0.5)/float64(h)*(z1-z0)
+		for i := 0; i < w; i++ {
+			x := x0 + (float64(i)+0.5)/float64(w)*(x1-x0)
+			want := x
+			if p.axis == axisZ {
+				want = z
+			}
+			e := math.Abs(p.value(nn, x, z) - want)
+			m.v[j*w+i] = e
+			if isNumber(e) {
+				m.min = math.Min(m.min, e)
+				m.max = math.Max(m.max, e)
+			}
+		}
+	}
+	return m
+}
+
+const (
+	legendGap   = 12
+	legendBar   = 16
+	legendWidth = legendGap + legendBar + 4 + 5*4*glyphScale
+	glyphScale  = 2
+)
+
+var (
+	nanColor    = color.RGBA{255, 0, 255, 255}
+	borderColor = color.RGBA{255, 255, 255, 255}
+	textColor   = color.RGBA{0, 0, 0, 255}
+)
+
+// decades returns the range of the logarithmic colour scale, in powers of ten.
+func (m heatmap) decades() (lo, hi int) {
+	if !isNumber(m.max) {
+		return 0, 1
+	}
+	lo, hi = -4, int(math.Ceil(math.Log10(m.max)))
+	if m.min > 0 {
+		lo = int(math.Max(float64(lo), math.Floor(math.Log10(m.min))))
+	}
+	if hi <= lo {
+		hi = lo + 1
+	}
+	return lo, hi
+}
+
+// render draws the heatmap on a logarithmic colour scale with a legend to its
+// right. The world border is marked where it lies inside the plotted area.
+func (m heatmap) render() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, m.w+legendWidth, m.h))
+	for i := range img.Pix {
+		img.Pix[i] = 255
+	}
+
+	lo, hi := m.decades()
+	scale := func(e float64) float64 {
+		if e <= 0 {
+			return 0
+		}
+		return (math.Log10(e) - float64(lo)) / float64(hi-lo)
+	}
+
+	for j := 0; j < m.h; j++ {
+		for i := 0; i < m.w; i++ {
+			e := m.v[j*m.w+i]
+			if isNumber(e) {
+				img.SetRGBA(i, j, colorScale(scale(e)))
+			} else {
+				img.SetRGBA(i, j, nanColor)
+			}
+		}
+	}
+
+	for _, b := range [2]float64{-worldBorder, worldBorder} {
+		if i := int((b - m.x0) / (m.x1 - m.x0) * float64(m.w)); b > m.x0 && b < m.x1 {
+			for j := 0; j < m.h; j++ {
+				img.SetRGBA(i, j, borderColor)
+			}
+		}
+		if j := int((b - m.z0) / (m.z1 - m.z0) * float64(m.h)); b > m.z0 && b < m.z1 {
+			for i := 0; i < m.w; i++ {
+				img.SetRGBA(i, j, borderColor)
+			}
+		}
+	}
+
+	// legend, with the largest error at the top
+	top, bottom := 5*glyphScale, m.h-5*glyphScale
+	bx := m.w + legendGap
+	for j := top; j < bottom; j++ {
+		c := colorScale(1 - float64(j-top)/float64(bottom-top-1))
+		for i := bx; i < bx+legendBar; i++ {
+			img.SetRGBA(i, j, c)
+		}
+	}
+	for _, t := range legendTicks(lo, hi, top, bottom) {
+		for i := bx + legendBar; i < bx+legendBar+3; i++ {
+			img.SetRGBA(i, t.y, textColor)
+		}
+		drawText(img, bx+legendBar+4, t.y-5*glyphScale/2, "1e"+strconv.Itoa(t.decade), textColor)
+	}
+
+	return img
+}
+
+// legendTick is a labelled power of ten on the legend, at row y.
+type legendTick struct {
+	decade, y int
+}
+
+// legendTicks returns the labelled decades from lo to hi, at most about
+// eight, on a legend bar from row top to bottom.
+func legendTicks(lo, hi, top, bottom int) []legendTick {
+	var ticks []legendTick
+	step := (hi-lo-1)/8 + 1
+	for d := lo; d <= hi; d += step {
+		j := bottom - 1 - int(float64(d-lo)/float64(hi-lo)*float64(bottom-top-1))
+		ticks = append(ticks, legendTick{d, j})
+	}
+	return ticks
+}
+
+// colorScale maps t in [0, 1] onto an approximation of the viridis colour map.
+func colorScale(t float64) color.RGBA {
+	stops := [...][3]float64{{68, 1, 84}, {59, 82, 139}, {33, 145, 140}, {94, 201, 98}, {253, 231, 37}}
+	t = math.Max(0, math.Min(1, t)) * float64(len(stops)-1)
+	i := int(math.Min(t, float64(len(stops)-2)))
+	f := t - float64(i)
+	var c [3]uint8
+	for k := range c {
+		c[k] = uint8(math.Round(lerp(f, stops[i][k], stops[i+1][k])))
+	}
+	return color.RGBA{c[0], c[1], c[2], 255}
+}
+
+// glyphs is a 3x5 pixel font covering the characters used in legend labels.
+var glyphs = map[rune][5]string{
+	'0': {"###", "#.#", "#.#", "#.#", "###"},
+	'1': {".#.", "##.", ".#.", ".#.", "###"},
+	'2': {"###", "..#", "###", "#..", "###"},
+	'3': {"###", "..#", "###", "..#", "###"},
+	'4': {"#.#", "#.#", "###", "..#", "..#"},
+	'5': {"###", "#..", "###", "..#", "###"},
+	'6': {"###", "#..", "###", "#.#", "###"},
+	'7': {"###", "..#", "..#", "..#", "..#"},
+	'8': {"###", "#.#", "###", "#.#", "###"},
+	'9': {"###", "#.#", "###", "..#", "###"},
+	'e': {"...", "###", "###", "#..", "###"},
+	'-': {"...", "...", "###", "...", "..."},
+}
+
+// drawText draws s with its top left corner at x, y, scaled by glyphScale.
+func drawText(img *image.RGBA, x, y int, s string, c color.RGBA) {
+	for _, r := range s {
+		g := glyphs[r]
+		for j, row := range g {
+			for i, p := range row {
+				if p != '#' {
+					continue
+				}
+				for dy := 0; dy < glyphScale; dy++ {
+					for dx := 0; dx < glyphScale; dx++ {
+						img.SetRGBA(x+i*glyphScale+dx, y+j*glyphScale+dy, c)
+					}
+				}
+			}
+		}
+		x += 4 * glyphScale
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image/color"
+	"image/png"
+	"math"
+	"testing"
+)
+
+func TestErrorMap(t *testing.T) {
+	p := genFromDimSeed(context.Background(), 1, genOptions{}, firstOfEachAxis)
+	if !p.okx || !p.okz {
+		t.Fatal("no parameters found")
+	}
+	for _, d := range []dfParams{p.x, p.z} {
+		nn := seededNoise(d.dimSeed, d.rl)
+		// pixel centres at -1e6 + 5e5 * (i + 1/2) and 2e3 + 1e3 * (j + 1/2)
+		m := errorMap(d, nn, -1e6, 2e3, 1e6, 5e3, 4, 3)
+		if len(m.v) != 12 {
+			t.Fatalf("%d values for 4x3 pixels", len(m.v))
+		}
+		min, max := math.Inf(1), math.Inf(-1)
+		for j := 0; j < 3; j++ {
+			for i := 0; i < 4; i++ {
+				x, z := -1e6+5e5*(float64(i)+0.5), 2e3+1e3*(float64(j)+0.5)
+				want := math.Abs(d.value(nn, x, z) - x)
+				if d.axis == axisZ {
+					want = math.Abs(d.value(nn, x, z) - z)
+				}
+				if got := m.v[j*4+i]; got != want {
+					t.Errorf("%s: pixel %d,%d is %g, expected %g", d.axis, i, j, got, want)
+				}
+				min, max = math.Min(min, want), math.Max(max, want)
+			}
+		}
+		if m.min != min || m.max != max {
+			t.Errorf("%s: range %g to %g, expected %g to %g", d.axis, m.min, m.max, min, max)
+		}
+	}
+}
+
+func TestDecades(t *testing.T) {
+	for _, c := range []struct {
+		min, max float64
+		lo, hi   int
+	}{
+		{0.5, 20, -1, 2},
+		{0, 20, -4, 2},
+		{1e-9, 1e-7, -4, -3},
+		{3, 3, 0, 1},
+		{math.Inf(1), math.Inf(-1), 0, 1}, // no finite values
+		{1e-3, 2e6, -3, 7},
+	} {
+		lo, hi := heatmap{min: c.min, max: c.max}.decades()
+		if lo != c.lo || hi != c.hi {
+			t.Errorf("errors %g to %g: decades %d to %d, expected %d to %d", c.min, c.max, lo, hi, c.lo, c.hi)
+		}
+	}
+}
+
+func TestLegendTicks(t *testing.T) {
+	for _, c := range [][2]int{{-4, 8}, {0, 1}, {-4, -3}, {-4, 30}} {
+		lo, hi := c[0], c[1]
+		ticks := legendTicks(lo, hi, 10, 500)
+		if len(ticks) == 0 || ticks[0].decade != lo || ticks[0].y != 499 {
+			t.Errorf("decades %d to %d: ticks %v do not start with %d at the bottom", lo, hi, ticks, lo)
+		}
+		if len(ticks) > 9 {
+			t.Errorf("decades %d to %d: %d ticks", lo, hi, len(ticks))
+		}
+		for i := 1; i < len(ticks); i++ {
+			if !(ticks[i].decade > ticks[i-1].decade && ticks[i].y < ticks[i-1].y) {
+				t.Errorf("decades %d to %d: ticks %v are not ordered", lo, hi, ticks)
+			}
+		}
+		if last := ticks[len(ticks)-1]; last.decade == hi && last.y != 10 {
+			t.Errorf("decades %d to %d: top decade at row %d", lo, hi, last.y)
+		}
+	}
+}
+
+func luminance(c color.RGBA) float64 {
+	return 0.2126*float64(c.R) + 0.7152*float64(c.G) + 0.0722*float64(c.B)
+}
+
+func TestRender(t *testing.T) {
+	// errors growing to the right, from 1e-3 to 1e3
+	const w, h = 64, 40
+	m := heatmap{w: w, h: h, x0: -1, z0: -1, x1: 1, z1: 1, v: make([]float64, w*h), min: 1e-3, max: 1e3}
+	for j := 0; j < h; j++ {
+		for i := 0; i < w; i++ {
+			m.v[j*w+i] = math.Pow(10, -3+6*float64(i)/(w-1))
+		}
+	}
+	m.v[0] = math.NaN()
+	img := m.render()
+
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		t.Fatal(err)
+	}
+	dec, err := png.Decode(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := dec.Bounds().Size(); s.X != w+legendWidth || s.Y != h {
+		t.Errorf("image is %v, expected %dx%d", s, w+legendWidth, h)
+	}
+
+	if img.RGBAAt(0, 0) != nanColor {
+		t.Errorf("NaN drawn as %v", img.RGBAAt(0, 0))
+	}
+	for i := 1; i < w; i++ {
+		if luminance(img.RGBAAt(i, h/2)) < luminance(img.RGBAAt(i-1, h/2)) {
+			t.Errorf("pixel %d is darker than its smaller neighbour", i)
+		}
+	}
+	for i := 1; i <= 256; i++ {
+		if luminance(colorScale(float64(i)/256)) < luminance(colorScale(float64(i-1)/256)) {
+			t.Errorf("colour scale darkens at %d/256", i)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// reportVersion is the version of the parameter report written by generate
+// and read by emit. It is increased whenever the meaning of existing fields
+// changes or fields needed to reproduce the functions are added.
+const reportVersion = 1
+
+// report is the JSON form of the parameters of both axes:
+//
+//	{
+//		"version": 1,
+//		"seed": <dimension seed>,
+//		"x": {"noise": "syph:a", "shift": [x, y, z], "slope": m, "offset": b, "xz_scale": s,
+//			"terms": [{"noise": "syph:b", "shift": [x, y, z], "slope": m}], "exact_region": R,
+//			"error": e, "certified_error": c, "usable_range": r},
+//		"z": {...}
+//	}
+//
+// shift, slope, offset and xz_scale are the values of dfParams, written with
+// full precision, terms the further noises of a combination, added to the
+// first, and absent for a single noise. exact_region, if present, is the
+// distance from the origin a spline correction is fitted over, which is
+// fitted again when the report is read. error is the estimated error in
+// blocks and certified_error, only present if certified, a proven bound on it
+// within usable_range, the distance from the origin in blocks over which the
+// fit holds, or within the world if that is absent. All three are
+// informational.
+type report struct {
+	Version int           `json:"version"`
+	Seed    int64         `json:"seed"`
+	X       *reportParams `json:"x"`
+	Z       *reportParams `json:"z"`
+}
+
+type reportParams struct {
+	Noise  string       `json:"noise"`
+	Shift  [3]float64   `json:"shift"`
+	Slope  float64      `json:"slope"`
+	Offset float64      `json:"offset"`
+	Scale  float64      `json:"xz_scale"`
+	Terms  []reportTerm `json:"terms,omitempty"`
+	Exact  float64      `json:"exact_region,omitempty"`
+	Error  *float64     `json:"error,omitempty"` // omitted if not a number
+	Cert   *float64     `json:"certified_error,omitempty"`
+	Reach  float64      `json:"usable_range,omitempty"`
+}
+
+type reportTerm struct {
+	Noise string     `json:"noise"`
+	Shift [3]float64 `json:"shift"`
+	Slope float64    `json:"slope"`
+}
+
+func newReportParams(p dfParams) *reportParams {
+	r := &reportParams{Noise: p.rl, Shift: [3]float64{p.x, p.y, p.z}, Slope: p.m, Offset: p.b, Scale: p.scale, Reach: p.reach}
+	if p.corr != nil {
+		r.Exact = p.corr.region
+	}
+	for _, t := range p.extra {
+		r.Terms = append(r.Terms, reportTerm{t.rl, [3]float64{t.x, t.y, t.z}, t.m})
+	}
+	if isNumber(p.err) {
+		e := p.err
+		r.Error = &e
+	}
+	if p.cert != 0 && isNumber(p.cert) {
+		c := p.cert
+		r.Cert = &c
+	}
+	return r
+}
+
+func (r *reportParams) params(dimSeed int64, a axis) (dfParams, error) {
+	if r == nil {
+		return dfParams{}, fmt.Errorf("missing parameters for %s", a)
+	}
+	if r.Noise == "" {
+		return dfParams{}, fmt.Errorf("%s: missing noise", a)
+	}
+	if r.Scale == 0 {
+		return dfParams{}, fmt.Errorf("%s: missing xz_scale", a)
+	}
+	if err := checkInputScale(r.Scale); err != nil {
+		return dfParams{}, fmt.Errorf("%s: %v", a, err)
+	}
+	p := dfParams{dimSeed: dimSeed, rl: qualify(r.Noise), axis: a, x: r.Shift[0], y: r.Shift[1], z: r.Shift[2], m: r.Slope, b: r.Offset, scale: r.Scale, reach: r.Reach}
+	for _, t := range r.Terms {
+		if t.Noise == "" {
+			return dfParams{}, fmt.Errorf("%s: missing noise of a term", a)
+		}
+		p.extra = append(p.extra, dfTerm{qualify(t.Noise), t.Shift[0], t.Shift[1], t.Shift[2], t.Slope})
+	}
+	if err := checkRegion(r.Exact); err != nil {
+		return dfParams{}, fmt.Errorf("%s: %v", a, err)
+	}
+	p.err = p.estimateError(sharedNoises.get(dimSeed, p.rl))
+	if !validateParams(p) {
+		return dfParams{}, fmt.Errorf("%s: parameters are not finite", a)
+	}
+	if r.Exact != 0 {
+		return p.withCorrection(r.Exact)
+	}
+	return p, nil
+}
+
+func writeReport(w io.Writer, dimSeed int64, t twoParams) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(report{reportVersion, dimSeed, newReportParams(t.x), newReportParams(t.z)})
+}
+
+func writeReportFile(name string, dimSeed int64, t twoParams) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := writeReport(f, dimSeed, t); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readReport reads a report, recomputing the error of the parameters rather
+// than trusting the file. Certified bounds are not read, as they are too
+// expensive to check.
+func readReport(r io.Reader) (dimSeed int64, t twoParams, err error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	var rep report
+	if err := dec.Decode(&rep); err != nil {
+		return 0, t, err
+	}
+	if rep.Version != reportVersion {
+		return 0, t, fmt.Errorf("unsupported report version %d, expected %d", rep.Version, reportVersion)
+	}
+	if t.x, err = rep.X.params(rep.Seed, axisX); err != nil {
+		return 0, t, err
+	}
+	if t.z, err = rep.Z.params(rep.Seed, axisZ); err != nil {
+		return 0, t, err
+	}
+	t.okx, t.okz = true, true
+	return rep.Seed, t, nil
+}
+
+func emitMain(args []string) {
+	flags := flag.NewFlagSet("emit", flag.ExitOnError)
+	from := flags.String("from", "", "report `file` written by dfcoord -params (required)")
+	var pf packFlags
+	pf.register(flags)
+	cert := flags.Bool("certify", false, "prove a bound on the error of each function over the world")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: dfcoord emit -from <params.json> [flags]")
+		fmt.Fprintln(flags.Output(), "Writes the data pack files for saved parameters without searching.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 0 || *from == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+	pf.check()
+
+	f, err := os.Open(*from)
+	if err != nil {
+		log.Fatal(err)
+	}
+	dimSeed, t, err := readReport(f)
+	f.Close()
+	if err != nil {
+		log.Fatalf("%s: %v", *from, err)
+	}
+	for _, p := range []dfParams{t.x, t.z} {
+		logParams(p)
+	}
+	if *cert {
+		certify(&t)
+	}
+
+	if err := pf.write(".", dimSeed, t); err != nil {
+		log.Fatal(err)
+	}
+}
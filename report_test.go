@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReportRoundTrip(t *testing.T) {
+	nn := seededNoise(7, "syph:3")
+	p := dfParams{7, "syph:3", axisX, 12.345678901234567, 3.25, -101.1, 1.0 / 3, -2.0 / 7, 3e-10, 0, 0, 123456.5, nil, nil}
+	p.err = p.estimateError(nn)
+	q := p
+	q.rl, q.axis, q.m = "syph:q", axisZ, -q.m
+	q.extra = []dfTerm{{"syph:r", 1.5, -2, 3.75, 0.125}, {"syph:3", -7, 0, 1e-3, -1.0 / 9}}
+	q.err = q.estimateError(seededNoise(7, q.rl))
+
+	var b bytes.Buffer
+	if err := writeReport(&b, 7, twoParams{true, true, p, q}); err != nil {
+		t.Fatal(err)
+	}
+	seed, r, err := readReport(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seed != 7 || !reflect.DeepEqual(r.x, p) || !reflect.DeepEqual(r.z, q) {
+		t.Errorf("read seed %d and %+v, %+v, expected %+v, %+v", seed, r.x, r.z, p, q)
+	}
+}
+
+func TestReportErrors(t *testing.T) {
+	for _, s := range []string{
+		`{"version": 2, "seed": 1, "x": {"noise": "syph:a", "xz_scale": 1e-9}, "z": {"noise": "syph:b", "xz_scale": 1e-9}}`,
+		`{"version": 0, "seed": 1, "x": {"noise": "syph:a", "xz_scale": 1e-9}, "z": {"noise": "syph:b", "xz_scale": 1e-9}}`,
+		`{"version": 1, "seed": 1, "x": {"noise": "syph:a", "xz_scale": 1e-9, "terms": [{"slope": 1}]}, "z": {"noise": "syph:b", "xz_scale": 1e-9}}`,
+		`{"version": 1, "seed": 1, "x": {"noise": "syph:a", "xz_scale": 1e-9, "exact_region": -100}, "z": {"noise": "syph:b", "xz_scale": 1e-9}}`,
+		`{"version": 1, "seed": 1, "x": {"noise": "syph:a", "xz_scale": -1}, "z": {"noise": "syph:b", "xz_scale": 1e-9}}`,
+		`{"version": 1, "seed": 1, "x": {"noise": "syph:a", "xz_scale": 1e-9}, "z": {"noise": "syph:b"}}`,
+		`{"version": 1, "seed": 1, "x": {"noise": "syph:a", "xz_scale": 1e-9}}`,
+		`{"version": 1, "seed": 1, "x": {"noise": "syph:a", "xz_scale": 1e-9}, "z": {"noise": "", "xz_scale": 1e-9}}`,
+		`{"version": 1, "seed": 1, "x": {"noise": "syph:a", "xz_scale": 1e-9, "scale": 2}, "z": {"noise": "syph:b", "xz_scale": 1e-9}}`,
+	} {
+		if _, _, err := readReport(strings.NewReader(s)); err == nil {
+			t.Errorf("no error reading %s", s)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+)
+
+// seedProbes are the positions in noise space at which seed_ok compares the
+// noises of the pack with the values they have for the intended seed.
+var seedProbes = []coord{{0.5, 0.25, 0.125}, {37.3, -11.9, 81.6}, {-102.7, 53.1, -7.4}}
+
+// seedOkTolerance is how far a probed value may be from the expected one. The
+// game computes the same value exactly, while the noise of another seed is
+// practically never this close.
+const seedOkTolerance = 1e-9
+
+// seedOkFunction returns the JSON of a density function that is 1 if the
+// noises rls have their values for dimSeed at the probes and 0 otherwise.
+func seedOkFunction(dimSeed int64, rls []string) jsonObject {
+	type probe struct {
+		rl string
+		c  coord
+	}
+	var probes []probe
+	seen := make(map[string]bool)
+	for _, rl := range rls {
+		if seen[rl] {
+			continue
+		}
+		seen[rl] = true
+		for _, c := range seedProbes {
+			probes = append(probes, probe{rl, c})
+		}
+	}
+
+	// built from the innermost check outwards
+	var f any = 1.0
+	for i := len(probes) - 1; i >= 0; i-- {
+		p := probes[i]
+		v := sharedNoises.get(dimSeed, p.rl).getValue(p.c)
+		f = object(
+			"type", "minecraft:range_choice",
+			// a zero scale makes the noise constant at the shift
+			"input", object(
+				"type", "minecraft:shifted_noise",
+				"noise", p.rl,
+				"xz_scale", 0.0,
+				"y_scale", 0.0,
+				"shift_x", p.c.x,
+				"shift_y", p.c.y,
+				"shift_z", p.c.z,
+			),
+			"min_inclusive", v-seedOkTolerance,
+			"max_exclusive", v+seedOkTolerance,
+			"when_in_range", f,
+			"when_out_of_range", 0.0,
+		)
+	}
+	return object("type", "minecraft:flat_cache", "argument", f)
+}
+
+// validateSeedOk evaluates the written seed_ok function for the intended seed
+// and for another one.
+func validateSeedOk(fsys fs.FS, dimSeed int64, ns string) error {
+	for _, s := range []struct {
+		seed int64
+		want float64
+	}{{dimSeed, 1}, {dimSeed + 1, 0}} {
+		f, err := newDfLoader(fsys, s.seed).load(ns + ":seed_ok")
+		if err != nil {
+			return err
+		}
+		for _, c := range []intCoord{{0, 0, 0}, {-12345, 64, 678}} {
+			if v := f.compute(c); v != s.want {
+				return fmt.Errorf("%s:seed_ok is %g for seed %d, expected %g", ns, v, s.seed, s.want)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSeedOk(t *testing.T) {
+	b, err := json.Marshal(seedOkFunction(5, []string{"syph:a", "syph:b", "syph:a"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := fstest.MapFS{
+		"syph/worldgen/density_function/seed_ok.json": {Data: b},
+		"syph/worldgen/noise/a.json":                  {Data: []byte(noiseFile)},
+		"syph/worldgen/noise/b.json":                  {Data: []byte(noiseFile)},
+	}
+	if err := validateSeedOk(files, 5, "syph"); err != nil {
+		t.Error(err)
+	}
+	for _, seed := range []int64{-1, 0, 4, 6, 1 << 40} {
+		f, err := newDfLoader(files, seed).load("syph:seed_ok")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := f.compute(intCoord{1, 2, 3}); v != 0 {
+			t.Errorf("seed_ok is %g for seed %d", v, seed)
+		}
+	}
+}
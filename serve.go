@@ -0,0 +1,497 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The server runs searches as jobs on a fixed number of workers, taking them
+// from a bounded queue. Identical requests share a job, so that a finished
+// pack is served again without searching. Finished jobs are kept until more
+// than a configured number of them have finished after them.
+//
+//	POST   /generate          start a job, or find the job of the same request
+//	GET    /jobs/{id}          state and progress of a job
+//	GET    /jobs/{id}/pack.zip the pack of a finished job
+//	DELETE /jobs/{id}          cancel a job, or forget a finished one
+
+type jobState string
+
+const (
+	jobQueued    jobState = "queued"
+	jobRunning   jobState = "running"
+	jobDone      jobState = "done"
+	jobFailed    jobState = "failed"
+	jobCancelled jobState = "cancelled"
+)
+
+func (s jobState) finished() bool {
+	return s == jobDone || s == jobFailed || s == jobCancelled
+}
+
+// generateRequest is the body of POST /generate. The keys and values are those
+// of the flags of dfcoord, the seed may be given as a number or a string, as
+// JSON numbers lose precision beyond 2^53 in many clients.
+type generateRequest struct {
+	Seed      json.Number `json:"seed"`
+	Namespace string      `json:"namespace"`
+	Derived   bool        `json:"derived"`
+	Centre    string      `json:"centre"`
+	SeedOk    bool        `json:"seed_ok"`
+	Units     string      `json:"units"`
+	Origin    string      `json:"origin"`
+	Negate    bool        `json:"negate"`
+	Scale     float64     `json:"xz_scale"`
+	MaxError  float64     `json:"max_error"`
+	Noises    int         `json:"noises"`
+	Combine   int         `json:"combine"`
+	Exact     float64     `json:"exact"`
+}
+
+// normalize fills in the defaults of the flags and validates the request,
+// returning its search and pack options and a key equal for requests that
+// produce the same pack.
+func (r generateRequest) normalize() (dimSeed int64, sf searchFlags, pf packFlags, key string, err error) {
+	if r.Seed == "" {
+		return 0, sf, pf, "", errors.New("missing seed")
+	}
+	dimSeed, err = strconv.ParseInt(string(r.Seed), 10, 64)
+	if err != nil {
+		return 0, sf, pf, "", fmt.Errorf("invalid seed %q", r.Seed)
+	}
+	r.Seed = json.Number(strconv.FormatInt(dimSeed, 10))
+	if r.Namespace == "" {
+		r.Namespace = namespace
+	}
+	if r.Centre == "" {
+		r.Centre = "0,0"
+	}
+	if r.Origin == "" {
+		r.Origin = "0,0"
+	}
+	if r.Scale == 0 {
+		r.Scale = defaultInputScale
+	}
+	if r.Combine == 0 {
+		r.Combine = 1
+	}
+
+	sf = searchFlags{maxError: r.MaxError, noises: r.Noises, scale: r.Scale, combine: r.Combine, exact: r.Exact}
+	if err := sf.parse(); err != nil {
+		return 0, sf, pf, "", err
+	}
+	pf = packFlags{ns: r.Namespace, derived: r.Derived, centre: r.Centre, seedOk: r.SeedOk, units: r.Units, origin: r.Origin, negate: r.Negate}
+	if err := pf.parse(); err != nil {
+		return 0, sf, pf, "", err
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return 0, sf, pf, "", err
+	}
+	return dimSeed, sf, pf, string(b), nil
+}
+
+type job struct {
+	id     string
+	key    string
+	seed   int64
+	sf     searchFlags
+	pf     packFlags
+	ctx    context.Context // cancelled with the job
+	cancel context.CancelFunc
+
+	// guarded by the server
+	state      jobState
+	candidates int
+	err        error
+	params     json.RawMessage // report of the found parameters
+	pack       []byte
+}
+
+// jobStatus is the body of GET /jobs/{id}. candidates counts the candidates
+// found so far, params is the report written by dfcoord -params.
+type jobStatus struct {
+	ID         string          `json:"id"`
+	State      jobState        `json:"state"`
+	Candidates int             `json:"candidates"`
+	Error      string          `json:"error,omitempty"`
+	Params     json.RawMessage `json:"params,omitempty"`
+}
+
+type server struct {
+	queue         chan *job
+	keep          int
+	searchWorkers int // workers of each search, see genOptions
+
+	mu       sync.Mutex
+	jobs     map[string]*job
+	byKey    map[string]*job
+	finished []*job // oldest first
+}
+
+// newServer starts workers running the jobs of a queue of the given length,
+// each searching with searchWorkers goroutines, and keeps the results of up to
+// keep finished jobs.
+func newServer(workers, searchWorkers, queue, keep int) *server {
+	s := &server{
+		queue:         make(chan *job, queue),
+		keep:          keep,
+		searchWorkers: searchWorkers,
+		jobs:          make(map[string]*job),
+		byKey:         make(map[string]*job),
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for j := range s.queue {
+				s.run(j)
+			}
+		}()
+	}
+	return s
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/generate" {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		s.generate(w, r)
+		return
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, "/jobs/")
+	if rest == r.URL.Path {
+		httpError(w, http.StatusNotFound, "not found")
+		return
+	}
+	id, file := rest, ""
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		id, file = rest[:i], rest[i+1:]
+	}
+	switch {
+	case file == "" && r.Method == http.MethodGet:
+		s.status(w, id)
+	case file == "" && r.Method == http.MethodDelete:
+		s.delete(w, id)
+	case file == "pack.zip" && r.Method == http.MethodGet:
+		s.pack(w, id)
+	case file == "":
+		w.Header().Set("Allow", "GET, DELETE")
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+	case file == "pack.zip":
+		w.Header().Set("Allow", http.MethodGet)
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+	default:
+		httpError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// generate queues a job for the request, or returns the job of an earlier
+// identical request unless it failed or was cancelled.
+func (s *server) generate(w http.ResponseWriter, r *http.Request) {
+	var req generateRequest
+	dec := json.NewDecoder(io.LimitReader(r.Body, 1<<16))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, "invalid request: "+err.Error())
+		return
+	}
+	dimSeed, sf, pf, key, err := req.normalize()
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if j, ok := s.byKey[key]; ok && j.state != jobFailed && j.state != jobCancelled {
+		writeJSON(w, http.StatusOK, s.statusOf(j))
+		return
+	}
+	id, err := newJobID()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	sf.workers = s.searchWorkers
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{id: id, key: key, seed: dimSeed, sf: sf, pf: pf, ctx: ctx, cancel: cancel, state: jobQueued}
+	select {
+	case s.queue <- j:
+	default:
+		cancel()
+		w.Header().Set("Retry-After", "10")
+		httpError(w, http.StatusServiceUnavailable, "queue full")
+		return
+	}
+	s.jobs[id] = j
+	s.byKey[key] = j
+	log.Printf("job %s: queued for seed %d", id, dimSeed)
+	writeJSON(w, http.StatusAccepted, s.statusOf(j))
+}
+
+func (s *server) status(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		httpError(w, http.StatusNotFound, "unknown job")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.statusOf(j))
+}
+
+func (s *server) pack(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	j, ok := s.jobs[id]
+	var state jobState
+	var pack []byte
+	if ok {
+		state, pack = j.state, j.pack
+	}
+	s.mu.Unlock()
+	switch {
+	case !ok:
+		httpError(w, http.StatusNotFound, "unknown job")
+	case state != jobDone:
+		httpError(w, http.StatusConflict, fmt.Sprintf("job is %s", state))
+	default:
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"dfcoord-%d.zip\"", j.seed))
+		w.Write(pack)
+	}
+}
+
+// delete cancels a queued or running job, which stays visible as cancelled,
+// and forgets a finished one.
+func (s *server) delete(w http.ResponseWriter, id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		httpError(w, http.StatusNotFound, "unknown job")
+		return
+	}
+	if j.state.finished() {
+		s.forget(j)
+	} else {
+		j.cancel()
+		s.finish(j, jobCancelled)
+		log.Printf("job %s: cancelled", j.id)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// run runs a job unless it has been cancelled while queued.
+func (s *server) run(j *job) {
+	s.mu.Lock()
+	if j.state != jobQueued {
+		s.mu.Unlock()
+		return
+	}
+	j.state = jobRunning
+	s.mu.Unlock()
+
+	params, pack, err := s.build(j)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if j.state != jobRunning {
+		return
+	}
+	if err != nil {
+		j.err = err
+		s.finish(j, jobFailed)
+		log.Printf("job %s: %v", j.id, err)
+		return
+	}
+	j.params, j.pack = params, pack
+	s.finish(j, jobDone)
+	log.Printf("job %s: done", j.id)
+}
+
+// finish moves j to a final state, forgetting the oldest finished jobs beyond
+// those kept. s.mu must be held.
+func (s *server) finish(j *job, state jobState) {
+	j.state = state
+	s.finished = append(s.finished, j)
+	for len(s.finished) > s.keep {
+		s.forget(s.finished[0])
+	}
+}
+
+// forget removes a finished job. s.mu must be held.
+func (s *server) forget(j *job) {
+	delete(s.jobs, j.id)
+	if s.byKey[j.key] == j {
+		delete(s.byKey, j.key)
+	}
+	for i, f := range s.finished {
+		if f == j {
+			s.finished = append(s.finished[:i], s.finished[i+1:]...)
+			break
+		}
+	}
+}
+
+func (s *server) statusOf(j *job) jobStatus {
+	st := jobStatus{ID: j.id, State: j.state, Candidates: j.candidates, Params: j.params}
+	if j.err != nil {
+		st.Error = j.err.Error()
+	}
+	return st
+}
+
+// watch wraps a reduce callback for genFromDimSeed, counting the candidates of
+// j.
+func watch[T any](s *server, j *job, rd func(a T, first bool, d dfParams) (T, bool)) func(a T, first bool, d dfParams) (T, bool) {
+	return func(a T, first bool, d dfParams) (T, bool) {
+		s.mu.Lock()
+		j.candidates++
+		s.mu.Unlock()
+		return rd(a, first, d)
+	}
+}
+
+// build searches the parameters of j and returns their report and the zipped
+// pack.
+func (s *server) build(j *job) (params json.RawMessage, pack []byte, err error) {
+	opts := genOptions{maxError: j.sf.maxError, scale: j.sf.scale, workers: j.sf.workers, noises: j.sf.noises}
+	var t twoParams
+	if j.sf.combine > 1 {
+		pool := genFromDimSeed(j.ctx, j.seed, opts, watch(s, j, collectCandidates(combinePool)))
+		if err := j.ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		if t, err = pool.best(j.sf.combine); err != nil {
+			return nil, nil, err
+		}
+	} else {
+		t = genFromDimSeed(j.ctx, j.seed, opts, watch(s, j, firstOfEachAxis))
+		if err := j.ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		if err := t.found(); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := t.correct(j.sf.exact); err != nil {
+		return nil, nil, err
+	}
+
+	var b bytes.Buffer
+	if err := writeReport(&b, j.seed, t); err != nil {
+		return nil, nil, err
+	}
+	params = b.Bytes()
+
+	dir, err := os.MkdirTemp("", "dfcoord-pack")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(dir)
+	if err := j.pf.write(dir, j.seed, t); err != nil {
+		return nil, nil, err
+	}
+	pack, err = zipFolder(dir)
+	return params, pack, err
+}
+
+// zipFolder returns a zip of the files in dir, with paths relative to it.
+func zipFolder(dir string) ([]byte, error) {
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		f, err := w.Create(filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(data)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	enc.Encode(v)
+}
+
+func httpError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, struct {
+		Error string `json:"error"`
+	}{msg})
+}
+
+func serveMain(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := flags.String("addr", "localhost:8080", "`address` to listen on")
+	workers := flags.Int("workers", 1, "number of searches run at once")
+	j := flags.Int("j", 0, "number of noises each search searches at once, 0 for one per CPU")
+	queue := flags.Int("queue", 16, "number of jobs waiting for a worker before requests are refused")
+	keep := flags.Int("keep", 64, "number of finished jobs whose packs are kept")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: dfcoord serve [flags]")
+		fmt.Fprintln(flags.Output(), "Serves an HTTP JSON API generating packs on request.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *workers < 1 || *keep < 1 || *queue < 0 || *j < 0 {
+		log.Fatal("-workers and -keep must be positive, -queue and -j not negative")
+	}
+
+	log.Printf("listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, newServer(*workers, *j, *queue, *keep)))
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func postGenerate(t *testing.T, url, body string) (int, jobStatus) {
+	t.Helper()
+	resp, err := http.Post(url+"/generate", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var st jobStatus
+	if resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode, st
+}
+
+func getStatus(t *testing.T, url, id string) (int, jobStatus) {
+	t.Helper()
+	resp, err := http.Get(url + "/jobs/" + id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var st jobStatus
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode, st
+}
+
+func deleteJob(t *testing.T, url, id string) int {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodDelete, url+"/jobs/"+id, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func waitFinished(t *testing.T, url, id string) jobStatus {
+	t.Helper()
+	deadline := time.Now().Add(time.Minute)
+	for time.Now().Before(deadline) {
+		code, st := getStatus(t, url, id)
+		if code != http.StatusOK {
+			t.Fatalf("status of job %s: %d", id, code)
+		}
+		if st.State.finished() {
+			return st
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return jobStatus{}
+}
+
+func TestServeGenerate(t *testing.T) {
+	ts := httptest.NewServer(newServer(1, 0, 4, 4))
+	defer ts.Close()
+
+	code, st := postGenerate(t, ts.URL, `{"seed": 1, "units": "chunk"}`)
+	if code != http.StatusAccepted {
+		t.Fatalf("POST /generate: %d", code)
+	}
+	// the same request with defaults spelled out and the seed as a string
+	code, again := postGenerate(t, ts.URL, `{"seed": "1", "units": "chunk", "namespace": "syph", "combine": 1}`)
+	if code != http.StatusOK || again.ID != st.ID {
+		t.Errorf("identical request got %d, job %s, expected the job %s", code, again.ID, st.ID)
+	}
+
+	st = waitFinished(t, ts.URL, st.ID)
+	if st.State != jobDone {
+		t.Fatalf("job %s: %s", st.State, st.Error)
+	}
+	if st.Candidates < 2 {
+		t.Errorf("%d candidates counted, expected at least one per axis", st.Candidates)
+	}
+	if _, _, err := readReport(bytes.NewReader(st.Params)); err != nil {
+		t.Errorf("params: %v", err)
+	}
+
+	resp, err := http.Get(ts.URL + "/jobs/" + st.ID + "/pack.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET pack.zip: %d %s", resp.StatusCode, b)
+	}
+	z, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	worst, err := checkPack(z, 1, namespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range worst {
+		if !(m.deviation() <= 1) {
+			t.Errorf("pack does not match its seed: %s", m)
+		}
+	}
+	if _, err := z.Open(namespace + "/worldgen/density_function/chunk_x.json"); err != nil {
+		t.Error(err)
+	}
+
+	if code := deleteJob(t, ts.URL, st.ID); code != http.StatusNoContent {
+		t.Errorf("DELETE of a finished job: %d", code)
+	}
+	if code, _ := getStatus(t, ts.URL, st.ID); code != http.StatusNotFound {
+		t.Errorf("deleted job still found, %d", code)
+	}
+}
+
+func TestServeCancel(t *testing.T) {
+	ts := httptest.NewServer(newServer(1, 0, 4, 4))
+	defer ts.Close()
+
+	// the first job occupies the worker, so that the second one waits
+	var ids []string
+	for _, seed := range []string{"2", "3"} {
+		code, st := postGenerate(t, ts.URL, `{"seed": `+seed+`, "combine": 3}`)
+		if code != http.StatusAccepted {
+			t.Fatalf("POST /generate: %d", code)
+		}
+		ids = append(ids, st.ID)
+	}
+	for _, id := range ids {
+		if code := deleteJob(t, ts.URL, id); code != http.StatusNoContent {
+			t.Errorf("DELETE: %d", code)
+		}
+		if st := waitFinished(t, ts.URL, id); st.State != jobCancelled {
+			t.Errorf("job %s after DELETE", st.State)
+		}
+	}
+
+	resp, err := http.Get(ts.URL + "/jobs/" + ids[0] + "/pack.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("pack of a cancelled job: %d", resp.StatusCode)
+	}
+
+	// a cancelled request is searched again
+	code, st := postGenerate(t, ts.URL, `{"seed": 2, "combine": 3}`)
+	if code != http.StatusAccepted || st.ID == ids[0] {
+		t.Errorf("request of a cancelled job got %d, job %s", code, st.ID)
+	}
+	deleteJob(t, ts.URL, st.ID)
+}
+
+func TestServeErrors(t *testing.T) {
+	s := newServer(1, 0, 0, 1)
+	for _, c := range []struct {
+		method, path, body string
+		code               int
+	}{
+		{"POST", "/generate", `{}`, http.StatusBadRequest},
+		{"POST", "/generate", `{"seed": 1.5}`, http.StatusBadRequest},
+		{"POST", "/generate", `{"seed": 1, "colour": "red"}`, http.StatusBadRequest},
+		{"POST", "/generate", `{"seed": 1, "combine": 9}`, http.StatusBadRequest},
+		{"POST", "/generate", `{"seed": 1, "units": "mile"}`, http.StatusBadRequest},
+		{"GET", "/generate", ``, http.StatusMethodNotAllowed},
+		{"GET", "/jobs/0123", ``, http.StatusNotFound},
+		{"GET", "/jobs/0123/pack.zip", ``, http.StatusNotFound},
+		{"POST", "/jobs/0123", ``, http.StatusMethodNotAllowed},
+		{"GET", "/jobs/0123/other", ``, http.StatusNotFound},
+		{"GET", "/", ``, http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, httptest.NewRequest(c.method, c.path, strings.NewReader(c.body)))
+		if w.Code != c.code {
+			t.Errorf("%s %s %s: %d, expected %d", c.method, c.path, c.body, w.Code, c.code)
+		}
+	}
+}
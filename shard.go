@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A search is split across processes by giving each a shard of the noise
+// indices. Each shard writes the candidates it finds as JSON lines, and merge
+// reads the files of all shards and selects from their union.
+
+// shard restricts a search to the noise indices i with i mod n equal to k.
+// The zero value searches every noise.
+type shard struct {
+	k, n int64
+}
+
+func parseShard(s string) (shard, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 {
+		return shard{}, errors.New("expected k/n")
+	}
+	k, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return shard{}, err
+	}
+	n, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return shard{}, err
+	}
+	if n < 1 || k < 0 || k >= n {
+		return shard{}, fmt.Errorf("shard %d of %d does not exist", k, n)
+	}
+	return shard{k, n}, nil
+}
+
+func (s shard) stride() int64 {
+	if s.n == 0 {
+		return 1
+	}
+	return s.n
+}
+
+// candidateLine is a line of a candidate file, the parameters of one candidate
+// in the form of the report of the same version.
+type candidateLine struct {
+	Version int    `json:"version"`
+	Seed    int64  `json:"seed"`
+	Axis    string `json:"axis"`
+	reportParams
+}
+
+func writeCandidates(w io.Writer, dimSeed int64, pool candidatePool) error {
+	enc := json.NewEncoder(w)
+	for _, cs := range [][]dfParams{pool.x, pool.z} {
+		for _, p := range cs {
+			if err := enc.Encode(candidateLine{reportVersion, dimSeed, p.axis.String(), *newReportParams(p)}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readCandidates adds the candidates of a file to pool, skipping those it
+// already holds. All candidates must be for dimSeed.
+func readCandidates(r io.Reader, dimSeed int64, pool *candidatePool) error {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for n := 1; s.Scan(); n++ {
+		if strings.TrimSpace(s.Text()) == "" {
+			continue
+		}
+		dec := json.NewDecoder(strings.NewReader(s.Text()))
+		dec.DisallowUnknownFields()
+		var c candidateLine
+		if err := dec.Decode(&c); err != nil {
+			return fmt.Errorf("line %d: %v", n, err)
+		}
+		if c.Version != reportVersion {
+			return fmt.Errorf("line %d: unsupported version %d", n, c.Version)
+		}
+		if c.Seed != dimSeed {
+			return fmt.Errorf("line %d: candidate for seed %d, expected %d", n, c.Seed, dimSeed)
+		}
+		var a axis
+		switch c.Axis {
+		case "x":
+			a = axisX
+		case "z":
+			a = axisZ
+		default:
+			return fmt.Errorf("line %d: unknown axis %q", n, c.Axis)
+		}
+		p, err := c.params(c.Seed, a)
+		if err != nil {
+			return fmt.Errorf("line %d: %v", n, err)
+		}
+		pool.add(p)
+	}
+	return s.Err()
+}
+
+// candidateKey identifies a candidate read from a file by its noise and the
+// position it was fitted at.
+type candidateKey struct {
+	rl      string
+	axis    axis
+	x, y, z float64
+}
+
+// add adds p to the pool unless it holds the same candidate already, as
+// overlapping shards find.
+func (pool *candidatePool) add(p dfParams) {
+	k := candidateKey{p.rl, p.axis, p.x, p.y, p.z}
+	if pool.seen[k] {
+		return
+	}
+	if pool.seen == nil {
+		pool.seen = make(map[candidateKey]bool)
+	}
+	pool.seen[k] = true
+	if p.axis == axisZ {
+		pool.z = append(pool.z, p)
+	} else {
+		pool.x = append(pool.x, p)
+	}
+}
+
+// noiseIndex returns the position of the noise of p in the order the search
+// generates noises in, placing other noises last.
+func noiseIndex(p dfParams) int64 {
+	ns, id := split(p.rl)
+	i, err := strconv.ParseInt(id, 36, 64)
+	if ns != namespace || err != nil || i < 0 {
+		return math.MaxInt64
+	}
+	return i
+}
+
+// best selects the parameters of each axis as a search does: the first
+// candidate in search order, or with combine above 1 the best combination of
+// the first combinePool. Candidates are put in search order by their noise,
+// keeping the order of those of the same noise, so that merging the files of
+// all shards, each with at least combinePool candidates of each axis, selects
+// the same parameters as a single search.
+func (pool candidatePool) best(combine int) (twoParams, error) {
+	var t twoParams
+	for _, cs := range [][]dfParams{pool.x, pool.z} {
+		if len(cs) == 0 {
+			continue
+		}
+		for _, c := range cs[1:] {
+			if c.scale != cs[0].scale {
+				return t, fmt.Errorf("%s: candidates were searched with xz_scale %g and %g", c.axis, cs[0].scale, c.scale)
+			}
+		}
+		cs = append([]dfParams(nil), cs...)
+		sort.SliceStable(cs, func(i, j int) bool { return noiseIndex(cs[i]) < noiseIndex(cs[j]) })
+		if len(cs) > combinePool {
+			cs = cs[:combinePool]
+		}
+		p := cs[0]
+		if combine > 1 {
+			p = bestCombination(cs, combine)
+		}
+		if p.axis == axisX {
+			t.x, t.okx = p, true
+		} else {
+			t.z, t.okz = p, true
+		}
+	}
+	return t, t.found()
+}
+
+// writeCandidatesFile searches for the first count candidates of each axis
+// and writes them to the named file.
+func writeCandidatesFile(f *searchFlags, dimSeed int64, name string, count int) {
+	f.check()
+	if f.combine != 1 || f.exact != 0 {
+		log.Fatal("-combine and -exact apply when merging candidates")
+	}
+	if count < 1 {
+		log.Fatal("-count must be positive")
+	}
+	pool, err := runSearch(f, dimSeed, collectCandidates(count))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	file, err := os.Create(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := writeCandidates(file, dimSeed, pool); err != nil {
+		log.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%s: %d candidates for x and %d for z", name, len(pool.x), len(pool.z))
+}
+
+func mergeMain(args []string) {
+	flags := flag.NewFlagSet("merge", flag.ExitOnError)
+	seed := flags.Int64("seed", 0, "dimension `seed` the candidates were searched for (required)")
+	combine := flags.Int("combine", 1, "add up to `n` candidates of each axis with weights cancelling their curvature")
+	exact := flags.Float64("exact", 0, "correct the functions with a spline to within 0.01 blocks up to `blocks` from the origin, 0 to disable")
+	var pf packFlags
+	pf.register(flags)
+	params := flags.String("params", "", "also write the selected parameters to `file` for dfcoord emit")
+	cert := flags.Bool("certify", false, "prove a bound on the error of each function over the world")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: dfcoord merge -seed <dimension seed> [flags] <candidates.jsonl>...")
+		fmt.Fprintln(flags.Output(), "Selects from the candidates written by sharded searches as a search would and writes the pack.")
+		flags.PrintDefaults()
+	}
+	files := parseInterspersed(flags, args)
+
+	if len(files) == 0 || !isFlagSet(flags, "seed") {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *combine < 1 || *combine > maxCombine {
+		log.Fatalf("-combine must be between 1 and %d", maxCombine)
+	}
+	if err := checkRegion(*exact); err != nil {
+		log.Fatalf("invalid -exact: %v", err)
+	}
+	pf.check()
+
+	var pool candidatePool
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = readCandidates(f, *seed, &pool)
+		f.Close()
+		if err != nil {
+			log.Fatalf("%s: %v", name, err)
+		}
+	}
+	log.Printf("%d candidates for x and %d for z", len(pool.x), len(pool.z))
+
+	t, err := pool.best(*combine)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := t.correct(*exact); err != nil {
+		log.Fatal(err)
+	}
+	for _, p := range []dfParams{t.x, t.z} {
+		logParams(p)
+	}
+	if *cert {
+		certify(&t)
+	}
+	if *params != "" {
+		if err := writeReportFile(*params, *seed, t); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := pf.write(".", *seed, t); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseShard(t *testing.T) {
+	if s, err := parseShard("3/8"); err != nil || s != (shard{3, 8}) {
+		t.Errorf("parsed %v, %v", s, err)
+	}
+	for _, s := range []string{"", "3", "8/8", "-1/8", "0/0", "a/b", "1/2/3"} {
+		if _, err := parseShard(s); err == nil {
+			t.Errorf("no error parsing %q", s)
+		}
+	}
+}
+
+func TestMergeMatchesSearch(t *testing.T) {
+	// merging the candidates of every shard selects what one search does
+	const seed = 42
+	var files [2]bytes.Buffer
+	for k := range files {
+		pool := genFromDimSeed(context.Background(), seed, genOptions{shard: shard{int64(k), 2}, workers: 1}, collectCandidates(combinePool))
+		if err := writeCandidates(&files[k], seed, pool); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var merged candidatePool
+	for k := range files {
+		if err := readCandidates(&files[k], seed, &merged); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	first := genFromDimSeed(context.Background(), seed, genOptions{workers: 1}, firstOfEachAxis)
+	if got, err := merged.best(1); err != nil || !sameParams(got, first) {
+		t.Errorf("merged %+v, %v, searched %+v", got, err, first)
+	}
+	pool := genFromDimSeed(context.Background(), seed, genOptions{workers: 1}, collectCandidates(combinePool))
+	want, err := pool.best(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := merged.best(2); err != nil || !sameParams(got, want) {
+		t.Errorf("merged %+v, %v, searched %+v", got, err, want)
+	}
+}
+
+// sameParams reports whether both select the same functions, which candidate
+// files only keep the parameters of.
+func sameParams(a, b twoParams) bool {
+	same := func(p, q dfParams) bool {
+		return p.rl == q.rl && p.x == q.x && p.y == q.y && p.z == q.z && p.m == q.m && p.b == q.b && reflect.DeepEqual(p.extra, q.extra)
+	}
+	return a.okx == b.okx && a.okz == b.okz && same(a.x, b.x) && same(a.z, b.z)
+}
+
+func TestShardedCandidates(t *testing.T) {
+	const seed = 42
+	var pools [2]candidatePool
+	for k := range pools {
+		pools[k] = genFromDimSeed(context.Background(), seed, genOptions{shard: shard{int64(k), 2}}, collectCandidates(3))
+		for _, p := range append(pools[k].x, pools[k].z...) {
+			_, id := split(p.rl)
+			i, err := strconv.ParseInt(id, 36, 64)
+			if err != nil || i%2 != int64(k) {
+				t.Errorf("shard %d/2 searched %s", k, p.rl)
+			}
+		}
+	}
+
+	var files [2]bytes.Buffer
+	for k := range pools {
+		if err := writeCandidates(&files[k], seed, pools[k]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// merging is independent of the order of the files and of duplicates
+	var merged [2]twoParams
+	for i, order := range [][]int{{0, 1}, {1, 0, 1}} {
+		var pool candidatePool
+		for _, k := range order {
+			if err := readCandidates(bytes.NewReader(files[k].Bytes()), seed, &pool); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if len(pool.x) != 6 || len(pool.z) != 6 {
+			t.Fatalf("merged %d and %d candidates, expected 6 each", len(pool.x), len(pool.z))
+		}
+		var err error
+		if merged[i], err = pool.best(2); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(merged[0], merged[1]) {
+		t.Error("selection depends on the order of the candidate files")
+	}
+
+	if err := readCandidates(bytes.NewReader(files[0].Bytes()), seed+1, &candidatePool{}); err == nil {
+		t.Error("no error reading candidates for another seed")
+	}
+	if err := readCandidates(strings.NewReader(`{"version": 1, "seed": 42, "axis": "y", "noise": "syph:0", "xz_scale": 1e-9}`), seed, &candidatePool{}); err == nil {
+		t.Error("no error reading a candidate for an unknown axis")
+	}
+	if _, err := (candidatePool{x: pools[0].x}).best(1); err == nil {
+		t.Error("no error selecting without candidates for z")
+	}
+}
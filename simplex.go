@@ -0,0 +1,80 @@
+package main
+
+import (
+	"math"
+)
+
+// simplex is the game's SimplexNoise. Only the two dimensional noise used by
+// end_islands is implemented.
+type simplex struct {
+	p [256]byte
+	o coord // offset, unused in two dimensions but drawn from the random
+}
+
+var (
+	simplexF2 = 0.5 * (math.Sqrt(3) - 1)
+	simplexG2 = (3 - math.Sqrt(3)) / 6
+)
+
+func newSimplex(r *legacyRandom) *simplex {
+	n := new(simplex)
+	n.o.x = r.float64() * 256.0
+	n.o.y = r.float64() * 256.0
+	n.o.z = r.float64() * 256.0
+
+	for i := range n.p {
+		n.p[i] = byte(i)
+	}
+
+	// fisher-yates shuffle
+	for i := range n.p {
+		j := int(r.boundedInt32(int32(256 - i)))
+		n.p[i], n.p[i+j] = n.p[i+j], n.p[i]
+	}
+	return n
+}
+
+func (n *simplex) r(i int) int {
+	return int(n.p[i&0xFF])
+}
+
+// simplexCorner is the contribution of a corner at offset x, y with the gradient
+// g, within the radius given by the squared distance d.
+func simplexCorner(g int, x, y, d float64) float64 {
+	h := d - x*x - y*y
+	if h < 0 {
+		return 0
+	}
+	h *= h
+	return h * h * gradDot(g, x, y, 0)
+}
+
+// value2 matches SimplexNoise.getValue(x, y).
+func (n *simplex) value2(x, y float64) float64 {
+	// skew onto the grid of the simplex cells
+	f := (x + y) * simplexF2
+	i := int(javaFloor(x + f))
+	j := int(javaFloor(y + f))
+	h := float64(i+j) * simplexG2
+	x0 := x - (float64(i) - h)
+	y0 := y - (float64(j) - h)
+
+	// the middle corner of the cell's triangle
+	var m, k int
+	if x0 > y0 {
+		m, k = 1, 0
+	} else {
+		m, k = 0, 1
+	}
+	x1 := x0 - float64(m) + simplexG2
+	y1 := y0 - float64(k) + simplexG2
+	x2 := x0 - 1 + 2*simplexG2
+	y2 := y0 - 1 + 2*simplexG2
+
+	v, w := i&0xFF, j&0xFF
+	g0 := n.r(v+n.r(w)) % 12
+	g1 := n.r(v+m+n.r(w+k)) % 12
+	g2 := n.r(v+1+n.r(w+1)) % 12
+
+	return 70 * (simplexCorner(g0, x0, y0, 0.5) + simplexCorner(g1, x1, y1, 0.5) + simplexCorner(g2, x2, y2, 0.5))
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"math"
+	"sort"
+	"strings"
+)
+
+// unitSizes are the units coordinates can be written in, with their size in
+// blocks. They are powers of two, so converting the slope is exact.
+var unitSizes = map[string]float64{
+	"block":  1,
+	"quart":  4,
+	"chunk":  16,
+	"region": 512,
+}
+
+// unitTransform maps a block coordinate c onto (c - origin) / size, negated
+// if requested. The result is not floored, a chunk coordinate of 1.5 is the
+// middle of chunk 1.
+type unitTransform struct {
+	unit   string
+	origin [2]float64 // in blocks, for x and z
+	negate bool
+}
+
+// name returns the name of the function of axis a in the unit.
+func (u unitTransform) name(a axis) string {
+	return u.unit + "_" + a.String()
+}
+
+// apply folds the transform into the slope and offset of p, so that the
+// emitted function needs no further nodes.
+func (u unitTransform) apply(p dfParams) dfParams {
+	s := 1 / unitSizes[u.unit]
+	if u.negate {
+		s = -s
+	}
+	o := u.origin[0]
+	if p.axis == axisZ {
+		o = u.origin[1]
+	}
+	p.b = s * (p.b - o/p.amplifier())
+	p.m = s * p.m
+	if p.corr != nil {
+		c := *p.corr
+		c.factor *= float32(s)
+		p.corr = &c
+	}
+	if p.extra != nil {
+		extra := make([]dfTerm, len(p.extra))
+		for i, t := range p.extra {
+			t.m *= s
+			extra[i] = t
+		}
+		p.extra = extra
+	}
+	p.err = math.Abs(s) * p.err
+	p.cert = math.Abs(s) * p.cert
+	p.reach = 0
+	return p
+}
+
+// of returns the coordinate v in blocks converted by the transform.
+func (u unitTransform) of(v float64, a axis) float64 {
+	o := u.origin[0]
+	if a == axisZ {
+		o = u.origin[1]
+	}
+	r := (v - o) / unitSizes[u.unit]
+	if u.negate {
+		r = -r
+	}
+	return r
+}
+
+// parseUnits parses a comma separated list of unit names.
+func parseUnits(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var r []string
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := unitSizes[name]; !ok {
+			return nil, fmt.Errorf("unknown unit %q, expected one of %s", name, unitNames())
+		}
+		r = append(r, name)
+	}
+	return r, nil
+}
+
+func unitNames() string {
+	var names []string
+	for name := range unitSizes {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return unitSizes[names[i]] < unitSizes[names[j]] })
+	return strings.Join(names, ", ")
+}
+
+// validateUnits evaluates the written unit functions and compares them to the
+// converted values of syph:x and syph:z.
+func validateUnits(fsys fs.FS, seed int64, ns string, t twoParams, units []unitTransform) (int, error) {
+	l := newDfLoader(fsys, seed)
+	coords := make(map[axis]densityFunction)
+	for _, a := range []axis{axisX, axisZ} {
+		f, err := l.load(ns + ":" + a.String())
+		if err != nil {
+			return 0, err
+		}
+		coords[a] = f
+	}
+
+	var checks []writtenCheck
+	for _, u := range units {
+		for _, p := range []dfParams{t.x, t.z} {
+			a := p.axis
+			// folding the offset rounds differently than converting the
+			// value, by a few units in the last place of the sum, which the
+			// amplifier scales up
+			tol := 64 * 0x1p-52 * p.amplifier() / unitSizes[u.unit]
+			for _, v := range validationSamples {
+				c := intCoord{v, 0, v}
+				checks = append(checks, writtenCheck{u.name(a), c, u.of(coords[a].compute(c), a), tol})
+			}
+		}
+	}
+	return checkWritten(l, ns, checks)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"testing/fstest"
+)
+
+func TestUnitFunctions(t *testing.T) {
+	files := testPack(t)
+	p := genFromDimSeed(context.Background(), 1, genOptions{}, firstOfEachAxis)
+	add := func(u unitTransform, written unitTransform) {
+		for _, d := range []dfParams{p.x, p.z} {
+			b, err := json.Marshal(written.apply(d).function())
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[namespace+"/worldgen/density_function/"+u.name(d.axis)+".json"] = &fstest.MapFile{Data: b}
+		}
+	}
+
+	units := []unitTransform{
+		{"block", [2]float64{1234.5, -3e7}, false},
+		{"quart", [2]float64{1234.5, -3e7}, true},
+		{"chunk", [2]float64{0, 0}, true},
+		{"region", [2]float64{-512, 100}, false},
+	}
+	for _, u := range units {
+		add(u, u)
+	}
+	if _, err := validateUnits(files, 1, namespace, p, units); err != nil {
+		t.Error(err)
+	}
+
+	u := unitTransform{"chunk", [2]float64{0, 0}, false}
+	add(u, unitTransform{"chunk", [2]float64{16, 0}, false})
+	if _, err := validateUnits(files, 1, namespace, p, []unitTransform{u}); err == nil {
+		t.Error("no error for a function one chunk off")
+	}
+}
+
+func TestParseUnits(t *testing.T) {
+	u, err := parseUnits("chunk, quart")
+	if err != nil || len(u) != 2 || u[0] != "chunk" || u[1] != "quart" {
+		t.Errorf("parsed %q, %v", u, err)
+	}
+	if u, err := parseUnits(""); err != nil || len(u) != 0 {
+		t.Errorf("parsed %q, %v from nothing", u, err)
+	}
+	if _, err := parseUnits("chunk,furlong"); err == nil {
+		t.Error("no error for an unknown unit")
+	}
+}